@@ -0,0 +1,871 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/stefancocora/keybasectl/cmd/keybasectl/keybase"
+	log "github.com/stefancocora/keybasectl/internal/log"
+	"github.com/stefancocora/keybasectl/internal/version"
+)
+
+// checkOptions holds the flags of the commands checking users against keybase
+// every command registers the subset of flags it honours
+type checkOptions struct {
+	debug            bool
+	users            userFlag
+	identities       []*identityFlag
+	api              apiEndpointFlag
+	output           string
+	chunkSize        int
+	concurrency      int
+	retries          int
+	retryDelay       time.Duration
+	rateLimit        float64
+	timeout          time.Duration
+	requestTimeout   time.Duration
+	cacheDir         string
+	cacheTTL         time.Duration
+	offline          bool
+	reports          reportFlag
+	pins             fingerprintFlag
+	pinFile          string
+	validateKeys     bool
+	minRSABits       int
+	expiryWindowDays int
+	requireProofs    requireProofFlag
+	expectProofs     expectProofFlag
+	roster           string
+	config           string
+	cfg              *config
+}
+
+// commonFlags registers the flags selecting the users, the keybase API and the output
+func (o *checkOptions) commonFlags(fs *flag.FlagSet) {
+
+	fs.BoolVar(&o.debug, "debug", false, "turn on debugging")
+	fs.StringVar(&o.config, cfgName, "", cfgUsage)
+	fs.Var(&o.api, apiName, apiUsage)
+	fs.Var(&o.users, usName, usUsage)
+	fs.Var(&userFileFlag{users: &o.users}, usFileName, usFileUsage)
+	o.identities = newIdentityFlags()
+	for _, idf := range o.identities {
+
+		fs.Var(&idf.value, idf.name, idf.usage)
+	}
+	fs.StringVar(&o.output, outputName, outputText, outputUsage)
+	fs.Var(&o.reports, rptName, rptUsage)
+	fs.IntVar(&o.chunkSize, chunkSizeName, keybase.DefaultChunkSize, chunkSizeUsage)
+	fs.IntVar(&o.concurrency, concurrencyName, keybase.DefaultConcurrency, concurrencyUsage)
+	fs.IntVar(&o.retries, retriesName, keybase.DefaultMaxRetries, retriesUsage)
+	fs.DurationVar(&o.retryDelay, retryDelayName, keybase.DefaultRetryBaseDelay, retryDelayUsage)
+	fs.Float64Var(&o.rateLimit, rateLimitName, 0, rateLimitUsage)
+	fs.DurationVar(&o.timeout, timeoutName, 0, timeoutUsage)
+	fs.DurationVar(&o.requestTimeout, requestTimeoutName, keybase.DefaultTimeout, requestTimeoutUsage)
+	fs.StringVar(&o.cacheDir, cacheDirName, "", cacheDirUsage)
+	fs.DurationVar(&o.cacheTTL, cacheTTLName, 0, cacheTTLUsage)
+	fs.BoolVar(&o.offline, offlineName, false, offlineUsage)
+}
+
+// keyFlags registers the flags checking the users' public keys
+func (o *checkOptions) keyFlags(fs *flag.FlagSet) {
+
+	fs.Var(&o.pins, fpName, fpUsage)
+	fs.StringVar(&o.pinFile, fpFileName, "", fpFileUsage)
+	fs.BoolVar(&o.validateKeys, validateKeysName, false, validateKeysUsage)
+	fs.IntVar(&o.minRSABits, minRSABitsName, keybase.DefaultMinRSABits, minRSABitsUsage)
+	fs.IntVar(&o.expiryWindowDays, expiryWindowDaysName, 0, expiryWindowDaysUsage)
+}
+
+// proofFlags registers the flags checking the users' identity proofs
+func (o *checkOptions) proofFlags(fs *flag.FlagSet) {
+
+	fs.Var(&o.requireProofs, rpName, rpUsage)
+	fs.Var(&o.expectProofs, epName, epUsage)
+}
+
+// rosterFlags registers the flag reading the users and their rules from a roster file
+func (o *checkOptions) rosterFlags(fs *flag.FlagSet) {
+
+	fs.StringVar(&o.roster, rosterShortName, "", rosterUsage)
+	fs.StringVar(&o.roster, rosterName, "", rosterUsage)
+}
+
+// identitiesSet reports whether any of the external identity flags is set
+func (o *checkOptions) identitiesSet() bool {
+
+	for _, idf := range o.identities {
+
+		if idf.value.set {
+
+			return true
+		}
+	}
+	return false
+}
+
+// proofsChecked reports whether the identity proofs are checked, by the proof flags or a roster file
+func (o *checkOptions) proofsChecked() bool {
+
+	return o.requireProofs.set || o.expectProofs.set
+}
+
+// checkRun holds the state of a run of checks against keybase while its steps go
+type checkRun struct {
+	ctx      context.Context // cancelled on SIGINT/SIGTERM and when the --timeout elapses
+	opts     *checkOptions
+	textOut  io.Writer // free-form text output, discarded for the structured output formats
+	rpt      report
+	junit    junitReport
+	kbc      *keybase.Client
+	users    []string
+	roster   *roster // the roster file the users and their rules come from, when set
+	pins     map[string]string
+	kr       []keybase.PubKeyResult
+	combined *keybase.CombinedResult // feeds the lookups when set, see combinedLookup
+	errcl    error                   // the error of the combined lookup
+	uf       []string                // captures the users found
+	kf       []string                // captures the user's pubkey found
+	exitCode int
+}
+
+// checkStep is a single step of a run of checks
+// run returns false when the run can't carry on, the remaining steps are then skipped
+type checkStep struct {
+	run func(r *checkRun) bool
+	// skip records the step as not run, errctx is the cancellation of the run or nil when an earlier check failed
+	skip func(r *checkRun, errctx error)
+	// fed is true when the step makes no request once the combined lookup ran, a cancelled run still goes through it
+	fed bool
+}
+
+// the steps the check commands are made of, in the order they have to run
+var (
+	stepResolveIdentities  = checkStep{run: (*checkRun).resolveIdentities}
+	stepCombinedLookup     = checkStep{run: (*checkRun).combinedLookup}
+	stepLookupUsers        = checkStep{run: (*checkRun).lookupUsers, skip: (*checkRun).skipUsers, fed: true}
+	stepLookupKeys         = checkStep{run: (*checkRun).lookupKeys, skip: (*checkRun).skipKeys, fed: true}
+	stepVerifyFingerprints = checkStep{run: (*checkRun).verifyFingerprints, fed: true}
+	stepValidateKeys       = checkStep{run: (*checkRun).validateKeys, fed: true}
+	stepCheckProofs        = checkStep{run: (*checkRun).checkProofs, fed: true}
+)
+
+// runChecks registers and parses the command flags then runs the given steps
+// it returns the process exit value
+func runChecks(cmd *command, args []string, flags []func(o *checkOptions, fs *flag.FlagSet), steps ...checkStep) int {
+
+	var opts checkOptions
+
+	fs := flag.NewFlagSet(cmd.name, flag.ExitOnError)
+	for _, register := range flags {
+
+		register(&opts, fs)
+	}
+	fs.Usage = cmd.usage(fs)
+	_ = fs.Parse(args)
+
+	// step: the config file and the environment variables default the flags not given
+	cfg, errcfg := applyConfig(fs, opts.config)
+	if errcfg != nil {
+
+		loggingSetup(opts.debug)
+		fmt.Fprintf(os.Stdout, "invalid configuration! flag: \"%s\": %v\n", cfgName, errcfg)
+		return exitUsage
+	}
+	opts.cfg = cfg
+
+	ctx, cancel := runContext(opts.timeout)
+	defer cancel()
+
+	r := &checkRun{ctx: ctx, opts: &opts, textOut: os.Stdout}
+	if !r.setup(fs) {
+
+		return r.finish()
+	}
+
+	for i, s := range steps {
+
+		// the run stops as soon as it's cancelled, the checks done so far are reported
+		// the steps fed from the combined lookup still report the users it fetched before
+		if errctx := r.ctx.Err(); errctx != nil && !(s.fed && r.combined != nil) {
+
+			r.interrupted(errctx)
+			r.skipSteps(steps[i:], errctx)
+			return r.finish()
+		}
+		if !s.run(r) {
+
+			errctx := r.ctx.Err()
+			if errctx != nil {
+
+				r.interrupted(errctx)
+			}
+			r.skipSteps(steps[i+1:], errctx)
+			return r.finish()
+		}
+	}
+
+	// a run cancelled during the combined lookup went through the steps it feeds
+	if errctx := r.ctx.Err(); errctx != nil {
+
+		r.interrupted(errctx)
+	}
+
+	return r.finish()
+}
+
+// skipSteps records the given steps as not run, errctx is the cancellation of the run or nil when an earlier check failed
+func (r *checkRun) skipSteps(steps []checkStep, errctx error) {
+
+	for _, s := range steps {
+
+		if s.skip != nil {
+
+			s.skip(r, errctx)
+		}
+	}
+}
+
+// interrupted records that the run got cancelled or timed out
+func (r *checkRun) interrupted(errctx error) {
+
+	reason := interruptReason(errctx)
+	log.ErrorLog.Printf("%s: %v", reason, errctx)
+	fmt.Fprintf(r.textOut, "%s, the results are partial\n", reason)
+	r.rpt.addError(reason)
+	r.fail(exitCodeFor(errctx))
+}
+
+// fail records a failure of the run with the given exit value
+func (r *checkRun) fail(code int) {
+
+	r.exitCode = firstFailure(r.exitCode, code)
+}
+
+// setup validates the arguments, the flags of fs and the environment variables and readies the keybase client
+func (r *checkRun) setup(fs *flag.FlagSet) bool {
+
+	var kbFl keybase.DebugFlag
+	var errs error
+
+	loggingSetup(r.opts.debug)
+
+	log.InfoLog.Println("starting engines")
+	if r.opts.cfg.path != "" {
+
+		log.DebugLog.Printf("config file %s: %v", r.opts.cfg.path, r.opts.cfg.values)
+	}
+
+	// unknown build metadata isn't fatal, none of the checks depends on it
+	bc, errbc := version.BuildContext()
+	if errbc != nil {
+
+		log.ErrorLog.Printf("unable to get the binary version: %v", errbc)
+	} else {
+
+		log.DebugLog.Printf("build context: %s", bc)
+	}
+
+	if !validOutput(r.opts.output) {
+
+		fmt.Fprintf(os.Stdout, "invalid output format! flag: \"%s\": %s, expected one of [text json yaml]\n", outputName, r.opts.output)
+		r.opts.output = outputText
+		r.fail(exitUsage)
+		return false
+	}
+	if r.opts.output != outputText {
+
+		r.textOut = ioutil.Discard
+	}
+
+	// the usage goes to stderr, it doesn't mix with the structured output
+	if fs.NArg() > 0 {
+
+		fmt.Fprintf(r.textOut, "unexpected argument(s): %v\n", fs.Args())
+		fs.Usage()
+		r.rpt.addError(fmt.Sprintf("unexpected argument(s): %v", fs.Args()))
+		r.fail(exitUsage)
+		return false
+	}
+
+	// step: a roster file lists the users and the rules they must satisfy
+	if r.opts.roster != "" && !r.loadRoster() {
+
+		return false
+	}
+
+	// step: check required flag/envvar, external identities can stand in for the users
+	// the environment variable and the config file only default the users when no user or external identity flag is given
+	if r.roster == nil && (r.opts.users.set || !r.opts.identitiesSet()) {
+
+		r.users, errs = resolveUsers(r.opts.users, r.opts.cfg.users())
+		if errs != nil {
+
+			log.ErrorLog.Printf("required flag or environment variable not set! flag: %s, environmentVariable: %v", usName, usEnv)
+			fmt.Fprintf(r.textOut, "required flag or environment variable not set! flag: \"%s\", environmentVariable: \"%v\"\n", usName, usEnv)
+			r.rpt.addError(fmt.Sprintf("required flag or environment variable not set! flag: %s, environmentVariable: %v", usName, usEnv))
+			r.fail(exitUsage)
+			return false
+		}
+	}
+	r.rpt.addInputs(keybase.SelectorUsername, keybase.NormaliseUsernames(r.users))
+	if r.roster != nil {
+
+		for _, m := range r.roster.Members {
+
+			if len(m.Metadata) > 0 {
+
+				r.rpt.addMetadata(m.Username, m.Metadata)
+			}
+		}
+	}
+
+	// step: resolve the keybase API endpoint
+	apiURL, errapi := resolveAPIEndpoint(r.opts.api)
+	if errapi != nil {
+
+		log.ErrorLog.Printf("invalid keybase API endpoint: %v", errapi)
+		fmt.Fprintf(r.textOut, "invalid keybase API endpoint! flag: \"%s\", environmentVariable: \"%v\": %v\n", apiName, apiEnv, errapi)
+		r.rpt.addError(fmt.Sprintf("invalid keybase API endpoint: %v", errapi))
+		r.fail(exitUsage)
+		return false
+	}
+	log.DebugLog.Printf("targeting keybase API endpoint: %s", apiURL)
+
+	// step: load the fingerprint pins, the flags win over the file
+	pins, errpins := resolveFingerprintPins(r.opts.pins, r.opts.pinFile)
+	if errpins != nil {
+
+		log.ErrorLog.Printf("invalid fingerprint pins: %v", errpins)
+		fmt.Fprintf(r.textOut, "invalid fingerprint pins! flag: \"%s\": %v\n", fpFileName, errpins)
+		r.rpt.addError(fmt.Sprintf("invalid fingerprint pins: %v", errpins))
+		r.fail(exitUsage)
+		return false
+	}
+	// the roster pins give way to the pin file and the flags
+	if r.roster != nil {
+
+		for u, fpr := range r.roster.pins() {
+
+			if _, ok := pins[u]; !ok {
+
+				pins[u] = fpr
+			}
+		}
+	}
+	r.pins = pins
+
+	kbFl.NewDebugFlag(r.opts.debug)
+	log.DebugLog.Printf("current setting for the debug flag inside the keybase pkg: %v", kbFl.DebugSetting())
+
+	if r.opts.chunkSize < 1 || r.opts.concurrency < 1 {
+
+		fmt.Fprintf(r.textOut, "invalid lookup batching! flags: \"%s\", \"%s\" must be at least 1\n", chunkSizeName, concurrencyName)
+		r.rpt.addError(fmt.Sprintf("invalid lookup batching: %s and %s must be at least 1", chunkSizeName, concurrencyName))
+		r.fail(exitUsage)
+		return false
+	}
+
+	if r.opts.retries < 0 || r.opts.retryDelay < 0 || r.opts.rateLimit < 0 || r.opts.timeout < 0 || r.opts.requestTimeout < 0 || r.opts.cacheTTL < 0 {
+
+		fmt.Fprintf(r.textOut, "invalid retries, rate limit or timeouts! flags: \"%s\", \"%s\", \"%s\", \"%s\", \"%s\", \"%s\" must not be negative\n", retriesName, retryDelayName, rateLimitName, timeoutName, requestTimeoutName, cacheTTLName)
+		r.rpt.addError(fmt.Sprintf("invalid retries, rate limit or timeouts: %s, %s, %s, %s, %s and %s must not be negative", retriesName, retryDelayName, rateLimitName, timeoutName, requestTimeoutName, cacheTTLName))
+		r.fail(exitUsage)
+		return false
+	}
+
+	if r.opts.minRSABits < 0 || r.opts.expiryWindowDays < 0 {
+
+		fmt.Fprintf(r.textOut, "invalid key policy! flags: \"%s\", \"%s\" must not be negative\n", minRSABitsName, expiryWindowDaysName)
+		r.rpt.addError(fmt.Sprintf("invalid key policy: %s and %s must not be negative", minRSABitsName, expiryWindowDaysName))
+		r.fail(exitUsage)
+		return false
+	}
+
+	r.kbc = keybase.NewClient(apiURL)
+	r.kbc.ChunkSize = r.opts.chunkSize
+	r.kbc.Concurrency = r.opts.concurrency
+	r.kbc.MaxRetries = r.opts.retries
+	r.kbc.RetryBaseDelay = r.opts.retryDelay
+	r.kbc.RateLimit = r.opts.rateLimit
+	r.kbc.Timeout = r.opts.requestTimeout
+	r.kbc.Offline = r.opts.offline
+
+	// the cache is read by an offline run whatever its TTL
+	if r.opts.cacheTTL > 0 || r.opts.offline {
+
+		cache, errca := keybase.NewCache(r.opts.cacheDir, r.opts.cacheTTL)
+		if errca != nil {
+
+			log.ErrorLog.Printf("invalid cache directory: %v", errca)
+			fmt.Fprintf(r.textOut, "invalid cache directory! flag: \"%s\": %v\n", cacheDirName, errca)
+			r.rpt.addError(fmt.Sprintf("invalid cache directory: %v", errca))
+			r.fail(exitUsage)
+			return false
+		}
+		log.DebugLog.Printf("caching the lookups in %s for %s, offline: %v", cache.Dir, cache.TTL, r.kbc.Offline)
+		r.kbc.Cache = cache
+	}
+
+	return true
+}
+
+// loadRoster reads the users and the rules they must satisfy from the roster file
+// the roster replaces the user and external identity flags, its proof requirements add up with the proof flags
+func (r *checkRun) loadRoster() bool {
+
+	if r.opts.users.set || r.opts.identitiesSet() {
+
+		log.ErrorLog.Printf("the roster file can't be combined with the user or external identity flags")
+		fmt.Fprintf(r.textOut, "conflicting flags! flag: \"%s\" can't be combined with \"%s\" or the external identity flags\n", rosterName, usName)
+		r.rpt.addError(fmt.Sprintf("conflicting flags: %s can't be combined with %s or the external identity flags", rosterName, usName))
+		r.fail(exitUsage)
+		return false
+	}
+
+	ro, errro := loadRoster(r.opts.roster)
+	if errro != nil {
+
+		log.ErrorLog.Printf("%v", errro)
+		fmt.Fprintf(r.textOut, "invalid roster file! flag: \"%s\": %v\n", rosterName, errro)
+		r.rpt.addError(fmt.Sprintf("invalid roster file: %v", errro))
+		r.fail(exitUsage)
+		return false
+	}
+
+	r.roster = ro
+	r.users = ro.usernames()
+	if len(ro.RequireProofs) > 0 {
+
+		r.opts.requireProofs.value = append(r.opts.requireProofs.value, ro.RequireProofs...)
+		r.opts.requireProofs.set = true
+	}
+	if expected := ro.proofExpectations(); len(expected) > 0 {
+
+		r.opts.expectProofs.value = append(r.opts.expectProofs.value, expected...)
+		r.opts.expectProofs.set = true
+	}
+
+	return true
+}
+
+// resolveIdentities resolves the external identities to keybase users
+// the run carries on with the resolved users, the unresolved identities already fail it
+// it stops the run when there's no user left to look up
+func (r *checkRun) resolveIdentities() bool {
+
+	for _, idf := range r.opts.identities {
+
+		if !idf.value.set {
+
+			continue
+		}
+
+		rr, errrs := r.kbc.ResolveUsers(r.ctx, idf.selector, idf.value.value)
+		if errrs != nil {
+
+			r.fail(exitCodeFor(errrs))
+			log.ErrorLog.Printf("error during keybase %s lookup: %s", idf.name, errrs.Error())
+			if !isUserNotFound(errrs) && !isPartialLookup(errrs) {
+
+				fmt.Fprintf(r.textOut, "error : %s\n", errrs.Error())
+				r.rpt.addError(errrs.Error())
+				return false
+			}
+		}
+
+		r.rpt.addResolved(idf.selector, rr)
+		for _, res := range rr {
+
+			if res.Err != nil {
+
+				fmt.Fprintf(r.textOut, "%s: %s keybase lookup failed: %v\n", idf.name, res.Input, res.Err)
+			} else if res.Found {
+
+				fmt.Fprintf(r.textOut, "%s: %s resolved to keybase user: %s\n", idf.name, res.Input, res.Username)
+				r.users = append(r.users, res.Username)
+			} else {
+
+				fmt.Fprintf(r.textOut, "%s: %s not found during keybase lookup\n", idf.name, res.Input)
+			}
+		}
+	}
+
+	// nothing is left to look up when no identity resolved and no user was given, the run already failed
+	if len(r.users) == 0 {
+
+		log.DebugLog.Printf("no external identity resolved to a keybase user, skipping the lookups")
+		return false
+	}
+
+	return true
+}
+
+// combinedLookup looks the users, their public keys and, when they're checked, their identity proofs up in a single round trip
+// the lookup steps are then fed from its outcome, its failure is reported by the first of them
+func (r *checkRun) combinedLookup() bool {
+
+	r.combined, r.errcl = r.kbc.CombinedLookup(r.ctx, r.users, r.opts.proofsChecked())
+	if r.errcl != nil {
+
+		log.DebugLog.Printf("combined keybase lookup failed: %v", r.errcl)
+	}
+
+	return true
+}
+
+// userLookup returns the outcome of the user lookup, from the combined lookup when it ran
+func (r *checkRun) userLookup() ([]keybase.UserResult, error) {
+
+	if r.combined != nil {
+
+		return r.combined.Users, r.combined.UsersErr
+	}
+	if r.errcl != nil {
+
+		return nil, r.errcl
+	}
+	return r.kbc.UserLookup(r.ctx, r.users)
+}
+
+// pubKeyLookup returns the outcome of the public key lookup, from the combined lookup when it ran
+func (r *checkRun) pubKeyLookup() ([]keybase.PubKeyResult, error) {
+
+	if r.combined != nil {
+
+		return r.combined.PubKeys, r.combined.PubKeysErr
+	}
+	if r.errcl != nil {
+
+		return nil, r.errcl
+	}
+	return r.kbc.PubKeyLookup(r.ctx, r.users)
+}
+
+// proofsLookup returns the outcome of the identity proofs lookup, from the combined lookup when it ran
+func (r *checkRun) proofsLookup() ([]keybase.ProofsResult, error) {
+
+	if r.combined != nil {
+
+		return r.combined.Proofs, r.combined.ProofsErr
+	}
+	if r.errcl != nil {
+
+		return nil, r.errcl
+	}
+	return r.kbc.ProofsLookup(r.ctx, r.users)
+}
+
+// lookupUsers looks the users up against keybase
+func (r *checkRun) lookupUsers() bool {
+
+	var unf []string // captures the users not found
+
+	started := time.Now()
+	ur, errl := r.userLookup()
+	r.junit.addUserLookup(r.users, ur, errl, time.Since(started))
+	r.uf, unf = splitUserResults(ur)
+	r.rpt.addUsers(ur)
+	if errl == nil {
+
+		fmt.Fprintf(r.textOut, "user(s): %v found during keybase lookup\n", r.uf)
+		return true
+	}
+
+	r.fail(exitCodeFor(errl))
+	if isUserNotFound(errl) || isPartialLookup(errl) {
+
+		if len(r.uf) > 0 {
+
+			fmt.Fprintf(r.textOut, "user(s): %v found during keybase lookup\n", r.uf)
+		}
+		if len(unf) > 0 {
+
+			fmt.Fprintf(r.textOut, "user(s): %v not found during keybase lookup\n", unf)
+		}
+		for _, res := range ur {
+
+			if res.Err != nil {
+
+				fmt.Fprintf(r.textOut, "user: %s keybase lookup failed: %v\n", res.Username, res.Err)
+			}
+		}
+		log.ErrorLog.Printf("error during keybase user lookup: %s", errl.Error())
+
+		// a roster run reports every violation and the proof policy applies to every user found, they go through the remaining checks
+		if (r.roster != nil || r.opts.proofsChecked()) && len(r.uf) > 0 {
+
+			r.users = r.uf
+			if r.combined != nil {
+
+				r.combined = r.combined.Narrow(r.uf)
+			}
+			return true
+		}
+	} else if ase, ok := errl.(keybase.ErrorAPIStatus); ok {
+
+		fmt.Fprintf(r.textOut, "keybase API failure during keybase lookup: %s\n", ase.Error())
+		r.rpt.addError(ase.Error())
+		log.ErrorLog.Printf("keybase API failure during keybase user lookup: %s", ase.Error())
+	} else {
+
+		fmt.Fprintf(r.textOut, "error : %s\n", errl.Error())
+		r.rpt.addError(errl.Error())
+		log.ErrorLog.Printf("error : %s", errl.Error())
+	}
+
+	return false
+}
+
+// lookupKeys looks the users' public keys up against keybase
+func (r *checkRun) lookupKeys() bool {
+
+	var knf []string // captures the user's pubkey not found
+
+	started := time.Now()
+	kr, errpkl := r.pubKeyLookup()
+	r.junit.addPubKeyLookup(r.users, kr, errpkl, time.Since(started))
+	r.kr = kr
+	r.kf, knf = splitPubKeyResults(kr)
+	r.rpt.addKeys(kr)
+	if errpkl == nil {
+
+		fmt.Fprintf(r.textOut, "user(s): %v public key found during keybase public key lookup\n", r.kf)
+		return true
+	}
+
+	r.fail(exitCodeFor(errpkl))
+	if isPKNotFound(errpkl) || isPartialLookup(errpkl) {
+
+		if len(r.kf) > 0 {
+
+			fmt.Fprintf(r.textOut, "user(s): %v public key found during keybase public key lookup\n", r.kf)
+		}
+		if len(knf) > 0 {
+
+			fmt.Fprintf(r.textOut, "user(s): %v public key not found during keybase public key lookup\n", knf)
+		}
+		for _, res := range kr {
+
+			if res.Err != nil {
+
+				fmt.Fprintf(r.textOut, "user: %s keybase public key lookup failed: %v\n", res.Username, res.Err)
+			}
+		}
+		log.ErrorLog.Printf("error during keybase public key lookup: %s", errpkl.Error())
+
+		// a roster run reports every violation and the proof policy applies to the users without a key too
+		if r.roster != nil || r.opts.proofsChecked() {
+
+			return true
+		}
+	} else if ase, ok := errpkl.(keybase.ErrorAPIStatus); ok {
+
+		fmt.Fprintf(r.textOut, "keybase API failure during keybase public key lookup: %s\n", ase.Error())
+		r.rpt.addError(ase.Error())
+		log.ErrorLog.Printf("keybase API failure during keybase public key lookup: %s", ase.Error())
+	} else {
+
+		fmt.Fprintf(r.textOut, "error : %s\n", errpkl.Error())
+		r.rpt.addError(errpkl.Error())
+		log.ErrorLog.Printf("error : %s", errpkl.Error())
+	}
+
+	return false
+}
+
+// skipUsers records the user lookup as skipped, or as errored when the run got cancelled before it
+func (r *checkRun) skipUsers(errctx error) {
+
+	if errctx != nil {
+
+		r.junit.interruptUserLookup(r.users, errctx)
+		return
+	}
+	r.junit.skipUserLookup(r.users, "an earlier check failed")
+}
+
+// skipKeys records the public key lookup as skipped, or as errored when the run got cancelled before it
+func (r *checkRun) skipKeys(errctx error) {
+
+	if errctx != nil {
+
+		r.junit.interruptPubKeyLookup(r.users, errctx)
+		return
+	}
+	r.junit.skipPubKeyLookup(r.users, "an earlier check failed")
+}
+
+// verifyFingerprints verifies the public key fingerprints against the pinned ones
+func (r *checkRun) verifyFingerprints() bool {
+
+	if len(r.pins) == 0 {
+
+		return true
+	}
+
+	errfp := keybase.VerifyFingerprints(r.kr, r.pins)
+	if errfp == nil {
+
+		fmt.Fprintf(r.textOut, "user(s): %v public key fingerprint matches the pinned fingerprint\n", pinnedUsers(r.kr, r.pins))
+		return true
+	}
+
+	r.fail(exitCodeFor(errfp))
+	if fme, ok := errfp.(keybase.ErrorFingerprintMismatch); ok {
+
+		for _, m := range fme.Mismatches {
+
+			fmt.Fprintf(r.textOut, "user: %s public key fingerprint mismatch, expected: %s actual: %s\n", m.Username, m.Expected, m.Actual)
+			r.rpt.addUserError(m.Username, fmt.Sprintf("public key fingerprint mismatch, expected: %s actual: %s", m.Expected, m.Actual))
+		}
+	}
+	log.ErrorLog.Printf("error during fingerprint verification: %s", errfp.Error())
+
+	return true
+}
+
+// validateKeys parses and validates the public keys locally
+func (r *checkRun) validateKeys() bool {
+
+	if !r.opts.validateKeys {
+
+		return true
+	}
+
+	policy := keybase.KeyPolicy{
+		MinRSABits:   r.opts.minRSABits,
+		ExpiryWindow: time.Duration(r.opts.expiryWindowDays) * 24 * time.Hour,
+	}
+	kv, errkv := keybase.ValidatePubKeys(r.kr, policy)
+	for _, v := range kv {
+
+		if v.Info != nil {
+
+			fmt.Fprintf(r.textOut, "user: %s public key %s %s %d bits\n", v.Username, v.Info.Fingerprint, v.Info.Algorithm, v.Info.BitLength)
+		}
+		for _, p := range v.Problems {
+
+			fmt.Fprintf(r.textOut, "user: %s public key invalid: %s\n", v.Username, p)
+			r.rpt.addUserError(v.Username, "public key invalid: "+p)
+		}
+	}
+	if errkv != nil {
+
+		r.fail(exitCodeFor(errkv))
+		log.ErrorLog.Printf("error during public key validation: %s", errkv.Error())
+	} else if len(r.kf) > 0 {
+
+		fmt.Fprintf(r.textOut, "user(s): %v public key passed validation\n", r.kf)
+	}
+
+	return true
+}
+
+// checkProofs looks the users' identity proofs up and checks them against the requirements
+func (r *checkRun) checkProofs() bool {
+
+	var found, notFound []string
+
+	if !r.opts.proofsChecked() {
+
+		return true
+	}
+
+	pr, errprl := r.proofsLookup()
+	if errprl != nil {
+
+		r.fail(exitCodeFor(errprl))
+		log.ErrorLog.Printf("error during keybase proofs lookup: %s", errprl.Error())
+		if !isUserNotFound(errprl) && !isPartialLookup(errprl) {
+
+			fmt.Fprintf(r.textOut, "error : %s\n", errprl.Error())
+			r.rpt.addError(errprl.Error())
+			return false
+		}
+	}
+	r.rpt.addProofs(pr)
+
+	for _, p := range pr {
+
+		if p.Err != nil {
+
+			fmt.Fprintf(r.textOut, "user: %s keybase proofs lookup failed: %v\n", p.Username, p.Err)
+		} else if p.Found {
+
+			found = append(found, p.Username)
+		} else {
+
+			notFound = append(notFound, p.Username)
+		}
+	}
+	if len(notFound) > 0 {
+
+		fmt.Fprintf(r.textOut, "user(s): %v not found during keybase proofs lookup\n", notFound)
+	}
+
+	if errpc := keybase.CheckProofs(pr, r.opts.requireProofs.value, r.opts.expectProofs.value); errpc != nil {
+
+		r.fail(exitCodeFor(errpc))
+		if pve, ok := errpc.(keybase.ErrorProofViolation); ok {
+
+			for _, v := range pve.Violations {
+
+				fmt.Fprintf(r.textOut, "user: %s identity proof violation: %s\n", v.Username, v.Problem)
+				r.rpt.addUserError(v.Username, "identity proof violation: "+v.Problem)
+			}
+		}
+		log.ErrorLog.Printf("error during identity proofs check: %s", errpc.Error())
+	} else if len(found) > 0 {
+
+		fmt.Fprintf(r.textOut, "user(s): %v identity proofs satisfy the requirements\n", found)
+	}
+
+	return true
+}
+
+// finish writes the reports and the structured output, it returns the process exit value
+func (r *checkRun) finish() int {
+
+	if path, ok := r.opts.reports.value[reportJUnit]; ok {
+
+		if errw := r.junit.write(path); errw != nil {
+
+			log.ErrorLog.Printf("%v", errw)
+			fmt.Fprintf(os.Stderr, "error : %v\n", errw)
+			r.fail(exitFailure)
+		}
+	}
+
+	if r.opts.output != outputText {
+
+		if errw := r.rpt.write(os.Stdout, r.opts.output, r.exitCode == exitOK); errw != nil {
+
+			log.ErrorLog.Printf("unable to write the %s output: %v", r.opts.output, errw)
+			fmt.Fprintf(os.Stderr, "error : unable to write the %s output: %v\n", r.opts.output, errw)
+			r.fail(exitFailure)
+		}
+	}
+
+	log.InfoLog.Println("stopping engines, we're done")
+
+	return r.exitCode
+}
@@ -0,0 +1,206 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// command is a keybasectl subcommand
+type command struct {
+	// name is the argument selecting the command
+	name string
+	// synopsis sums up the arguments of the command in its usage line
+	synopsis string
+	// summary is the one line description shown in the help
+	summary string
+	// run parses the command arguments, runs it and returns the process exit value
+	run func(cmd *command, args []string) int
+}
+
+// the names of the commands
+const (
+	checkCmd   = "check"
+	lookupCmd  = "lookup"
+	keysCmd    = "keys"
+	proofsCmd  = "proofs"
+	versionCmd = "version"
+	helpCmd    = "help"
+)
+
+// defaultCmd runs when no command is given, so that `keybasectl --user alice` keeps running every check
+const defaultCmd = checkCmd
+
+// commands lists every command in the order shown in the help
+var commands = []*command{
+	{
+		name:     checkCmd,
+		synopsis: "(--user USERS | -f ROSTER) [flags]",
+		summary:  "Run every check: user lookup, public key lookup, fingerprint pins, key validation and identity proofs (default)",
+		run:      runCheck,
+	},
+	{
+		name:     lookupCmd,
+		synopsis: "--user USERS [flags]",
+		summary:  "Look the users up on keybase",
+		run:      runLookup,
+	},
+	{
+		name:     keysCmd,
+		synopsis: "--user USERS [flags]",
+		summary:  "Look the users' public keys up on keybase, verify the fingerprint pins and validate the keys",
+		run:      runKeys,
+	},
+	{
+		name:     proofsCmd,
+		synopsis: "--user USERS (--require-proof SERVICES | --expect-proof user=service:handle) [flags]",
+		summary:  "Check the users' identity proofs",
+		run:      runProofs,
+	},
+	{
+		name:     exportKeysCmd,
+		synopsis: "--user USERS (--out-dir DIR | --keyring FILE)",
+		summary:  "Write the users' primary PGP public keys as found on keybase",
+		run: func(_ *command, args []string) int {
+			return exportKeys(args)
+		},
+	},
+	{
+		name:     mockServerCmd,
+		synopsis: "--fixtures DIR [--listen ADDR]",
+		summary:  "Serve a mock of the keybase user lookup API from JSON fixtures",
+		run: func(_ *command, args []string) int {
+			return mockServer(args)
+		},
+	},
+	{
+		name:     versionCmd,
+		synopsis: "[--json]",
+		summary:  "Print the version and build metadata of keybasectl",
+		run:      runVersion,
+	},
+}
+
+// the flags of the commands checking users against keybase
+var (
+	lookupFlags = []func(o *checkOptions, fs *flag.FlagSet){(*checkOptions).commonFlags}
+	keysFlags   = []func(o *checkOptions, fs *flag.FlagSet){(*checkOptions).commonFlags, (*checkOptions).keyFlags}
+	proofsFlags = []func(o *checkOptions, fs *flag.FlagSet){(*checkOptions).commonFlags, (*checkOptions).proofFlags}
+	checkFlags  = []func(o *checkOptions, fs *flag.FlagSet){(*checkOptions).commonFlags, (*checkOptions).keyFlags, (*checkOptions).proofFlags, (*checkOptions).rosterFlags}
+)
+
+// runCheck runs every check, the way keybasectl did before it grew commands
+// the checks are fed from a single combined lookup
+func runCheck(cmd *command, args []string) int {
+
+	return runChecks(cmd, args, checkFlags, stepResolveIdentities, stepCombinedLookup, stepLookupUsers, stepLookupKeys, stepVerifyFingerprints, stepValidateKeys, stepCheckProofs)
+}
+
+// runLookup only looks the users up
+func runLookup(cmd *command, args []string) int {
+
+	return runChecks(cmd, args, lookupFlags, stepResolveIdentities, stepLookupUsers)
+}
+
+// runKeys checks the users' public keys, a user unknown to keybase has no public key
+func runKeys(cmd *command, args []string) int {
+
+	return runChecks(cmd, args, keysFlags, stepResolveIdentities, stepLookupKeys, stepVerifyFingerprints, stepValidateKeys)
+}
+
+// runProofs checks the users' identity proofs
+func runProofs(cmd *command, args []string) int {
+
+	return runChecks(cmd, args, proofsFlags, stepResolveIdentities, stepCheckProofs)
+}
+
+// findCommand returns the command with the given name, nil when there's none
+func findCommand(name string) *command {
+
+	for _, c := range commands {
+
+		if c.name == name {
+
+			return c
+		}
+	}
+	return nil
+}
+
+// usage returns the usage function of the command flag set
+func (c *command) usage(fs *flag.FlagSet) func() {
+
+	return func() {
+
+		fmt.Fprintf(fs.Output(), "Usage: %s %s %s\n\n%s\n\n", os.Args[0], c.name, c.synopsis, c.summary)
+		fs.PrintDefaults()
+	}
+}
+
+// printUsage writes the top level help listing the commands
+func printUsage(w io.Writer) {
+
+	fmt.Fprintf(w, "Usage: %s [COMMAND] [flags]\n\nkeybase automation tool, useful as a CI tool to test various keybase settings\n\nCommands:\n", os.Args[0])
+	for _, c := range commands {
+
+		fmt.Fprintf(w, "  %-12s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintf(w, "  %-12s %s\n", helpCmd, "Show this help or the help of a command")
+	fmt.Fprintf(w, "\nRun \"%s COMMAND --help\" for the flags of a command, %s runs when no command is given.\n", os.Args[0], defaultCmd)
+}
+
+// dispatch runs the command named by the first argument and returns the process exit value
+// the default command runs when the first argument is a flag or there are no arguments
+func dispatch(args []string) int {
+
+	if len(args) > 0 && isHelpFlag(args[0]) {
+
+		printUsage(os.Stdout)
+		return exitOK
+	}
+
+	name := defaultCmd
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+
+		name, args = args[0], args[1:]
+	}
+
+	switch {
+	case name == helpCmd && len(args) == 0:
+		printUsage(os.Stdout)
+		return exitOK
+	case name == helpCmd:
+		name, args = args[0], []string{"--help"}
+	}
+
+	cmd := findCommand(name)
+	if cmd == nil {
+
+		fmt.Fprintf(os.Stdout, "unknown command: %s\n\n", name)
+		printUsage(os.Stdout)
+		return exitUsage
+	}
+
+	return cmd.run(cmd, args)
+}
+
+// isHelpFlag reports whether the argument asks for the help
+func isHelpFlag(arg string) bool {
+
+	return arg == "-h" || arg == "-help" || arg == "--help" || arg == "--h"
+}
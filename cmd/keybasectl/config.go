@@ -0,0 +1,234 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/stefancocora/keybasectl/internal/version"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// configSetting binds a config file key to the flag it defaults and to its environment variable
+// the config keys are named after the flags
+type configSetting struct {
+	name string
+	env  string
+}
+
+// configSettings lists the settings a config file can hold, the precedence is file < environment variable < flag
+var configSettings = []configSetting{
+	{name: "debug", env: "KEYBASECTL_DEBUG"},
+	{name: apiName, env: apiEnv},
+	{name: usName, env: usEnv},
+	{name: outputName, env: "KEYBASECTL_OUTPUT"},
+	{name: timeoutName, env: "KEYBASECTL_TIMEOUT"},
+	{name: requestTimeoutName, env: "KEYBASECTL_REQUEST_TIMEOUT"},
+	{name: cacheDirName, env: "KEYBASECTL_CACHE_DIR"},
+	{name: cacheTTLName, env: "KEYBASECTL_CACHE_TTL"},
+	{name: offlineName, env: "KEYBASECTL_OFFLINE"},
+}
+
+// config holds the settings read from a config file, as flag values
+type config struct {
+	path   string
+	values map[string]string
+}
+
+// defaultConfigPath returns the path of the config file read when none is given, the empty string when there's no user config directory
+func defaultConfigPath() string {
+
+	ucd, errucd := os.UserConfigDir()
+	if errucd != nil {
+
+		return ""
+	}
+
+	return filepath.Join(ucd, version.BinaryName, "config.yaml")
+}
+
+// loadConfig reads the config file at path, the --config flag wins over the KEYBASECTL_CONFIG envvar
+// an empty path reads the default config file, it's fine for it not to exist
+// it runs before the logging is set up, hence doesn't log
+func loadConfig(path string) (*config, error) {
+
+	cfg := &config{values: make(map[string]string)}
+
+	if path == "" {
+
+		path = os.Getenv(cfgEnv)
+	}
+	explicit := path != ""
+	if !explicit {
+
+		path = defaultConfigPath()
+	}
+	if path == "" {
+
+		return cfg, nil
+	}
+
+	b, errrf := ioutil.ReadFile(path)
+	if errrf != nil {
+
+		if os.IsNotExist(errrf) && !explicit {
+
+			return cfg, nil
+		}
+		return nil, errors.Wrapf(errrf, "unable to read the config file: %s", path)
+	}
+
+	var raw map[string]interface{}
+	if errDec := yaml.Unmarshal(b, &raw); errDec != nil {
+
+		return nil, errors.Wrapf(errDec, "unable to decode the config file: %s", path)
+	}
+
+	for k, v := range raw {
+
+		if !knownSetting(k) {
+
+			return nil, errors.Errorf("unknown setting %q in the config file %s, expected one of %v", k, path, settingNames())
+		}
+
+		val, errv := configValue(v)
+		if errv != nil {
+
+			return nil, errors.Wrapf(errv, "invalid setting %q in the config file %s", k, path)
+		}
+		cfg.values[k] = val
+	}
+	cfg.path = path
+
+	return cfg, nil
+}
+
+// applyConfig loads the config file at path and defaults the flags of fs with it, see loadConfig and apply
+func applyConfig(fs *flag.FlagSet, path string) (*config, error) {
+
+	cfg, errcfg := loadConfig(path)
+	if errcfg != nil {
+
+		return nil, errcfg
+	}
+
+	return cfg, cfg.apply(fs)
+}
+
+// configValue turns a config file value into a flag value, a list becomes a comma separated value
+func configValue(v interface{}) (string, error) {
+
+	switch tv := v.(type) {
+	case nil:
+		return "", nil
+	case []interface{}:
+		var items []string
+		for _, i := range tv {
+
+			s, errs := configValue(i)
+			if errs != nil {
+
+				return "", errs
+			}
+			items = append(items, s)
+		}
+		return strings.Join(items, ","), nil
+	case map[interface{}]interface{}:
+		return "", errors.New("expected a value or a list, got a mapping")
+	}
+
+	return fmt.Sprint(v), nil
+}
+
+// apply defaults the flags of fs that weren't given on the command line
+// with the environment variable of the setting when it's set, with the config file value otherwise
+// the users aren't defaulted, see users
+func (cfg *config) apply(fs *flag.FlagSet) error {
+
+	given := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+
+		given[f.Name] = true
+	})
+
+	for _, s := range configSettings {
+
+		if s.name == usName || given[s.name] || fs.Lookup(s.name) == nil {
+
+			continue
+		}
+
+		val, ok := os.LookupEnv(s.env)
+		source := s.env
+		if !ok {
+
+			val, ok = cfg.values[s.name]
+			source = cfg.path
+		}
+		if !ok {
+
+			continue
+		}
+
+		if errs := fs.Set(s.name, val); errs != nil {
+
+			return errors.Wrapf(errs, "invalid value %q for %s from %s", val, s.name, source)
+		}
+	}
+
+	return nil
+}
+
+// users returns the default user list of the config file, nil when it holds none
+// the --user flag and the KEYBASECTL_USER envvar win over it
+func (cfg *config) users() []string {
+
+	if v, ok := cfg.values[usName]; ok && v != "" {
+
+		return strings.Split(v, ",")
+	}
+	return nil
+}
+
+// knownSetting reports whether the config file key is a supported setting
+func knownSetting(name string) bool {
+
+	for _, s := range configSettings {
+
+		if s.name == name {
+
+			return true
+		}
+	}
+	return false
+}
+
+// settingNames returns the supported config file keys, sorted
+func settingNames() []string {
+
+	var names []string
+	for _, s := range configSettings {
+
+		names = append(names, s.name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,133 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// unsetEnv unsets the environment variable for the duration of the test
+func unsetEnv(t *testing.T, name string) {
+
+	t.Setenv(name, "")
+	os.Unsetenv(name)
+}
+
+// testFlags returns a flag set holding a few of the flags a config file defaults
+func testFlags(output *string, timeout *time.Duration, cacheDir *string) *flag.FlagSet {
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.StringVar(output, outputName, outputText, outputUsage)
+	fs.DurationVar(timeout, timeoutName, 0, timeoutUsage)
+	fs.StringVar(cacheDir, cacheDirName, "", cacheDirUsage)
+	return fs
+}
+
+func TestConfigApplyPrecedence(t *testing.T) {
+
+	var output, cacheDir string
+	var timeout time.Duration
+
+	unsetEnv(t, "KEYBASECTL_OUTPUT")
+	t.Setenv("KEYBASECTL_TIMEOUT", "2m")
+	t.Setenv("KEYBASECTL_CACHE_DIR", "/from/env")
+
+	fs := testFlags(&output, &timeout, &cacheDir)
+	if errp := fs.Parse([]string{"--" + cacheDirName, "/from/flag"}); errp != nil {
+
+		t.Fatalf("unexpected error: %v", errp)
+	}
+
+	cfg := &config{path: "config.yaml", values: map[string]string{
+		outputName:   outputYAML,
+		timeoutName:  "1m",
+		cacheDirName: "/from/file",
+	}}
+	if erra := cfg.apply(fs); erra != nil {
+
+		t.Fatalf("unexpected error: %v", erra)
+	}
+
+	if output != outputYAML {
+
+		t.Errorf("expected the config file to default the output, got %s", output)
+	}
+	if timeout != 2*time.Minute {
+
+		t.Errorf("expected the environment variable to win over the config file, got timeout %s", timeout)
+	}
+	if cacheDir != "/from/flag" {
+
+		t.Errorf("expected the flag to win over the environment variable, got cache dir %s", cacheDir)
+	}
+}
+
+func TestConfigApplyInvalidValue(t *testing.T) {
+
+	var output, cacheDir string
+	var timeout time.Duration
+
+	unsetEnv(t, "KEYBASECTL_TIMEOUT")
+
+	fs := testFlags(&output, &timeout, &cacheDir)
+	cfg := &config{path: "config.yaml", values: map[string]string{timeoutName: "soon"}}
+	erra := cfg.apply(fs)
+	if erra == nil || !strings.Contains(erra.Error(), "config.yaml") {
+
+		t.Errorf("expected an error naming the config file, got %v", erra)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if errw := ioutil.WriteFile(path, []byte("user: [alice, bob]\noutput: json\ncache-ttl: 1h\n"), 0600); errw != nil {
+
+		t.Fatalf("unexpected error: %v", errw)
+	}
+
+	cfg, errl := loadConfig(path)
+	if errl != nil {
+
+		t.Fatalf("unexpected error: %v", errl)
+	}
+	if got := strings.Join(cfg.users(), ","); got != "alice,bob" {
+
+		t.Errorf("expected users alice,bob, got %s", got)
+	}
+	if cfg.values[outputName] != outputJSON || cfg.values[cacheTTLName] != "1h" {
+
+		t.Errorf("expected the output and cache-ttl settings, got %v", cfg.values)
+	}
+
+	if errw := ioutil.WriteFile(path, []byte("colour: blue\n"), 0600); errw != nil {
+
+		t.Fatalf("unexpected error: %v", errw)
+	}
+	if _, errl := loadConfig(path); errl == nil {
+
+		t.Errorf("expected an unknown setting to be rejected")
+	}
+
+	if _, errl := loadConfig(filepath.Join(t.TempDir(), "missing.yaml")); errl == nil {
+
+		t.Errorf("expected an explicit config file that doesn't exist to be rejected")
+	}
+}
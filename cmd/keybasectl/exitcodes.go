@@ -0,0 +1,87 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/url"
+
+	"github.com/pkg/errors"
+	"github.com/stefancocora/keybasectl/cmd/keybasectl/keybase"
+)
+
+// the process exit values, they're part of the cli contract so scripts can branch on the outcome
+// keep them stable and in sync with the README
+const (
+	// exitOK is the exit value when every check passed
+	exitOK = 0
+	// exitFailure is the exit value of any failure without a more specific exit value
+	exitFailure = 1
+	// exitUsage is the exit value when the flags or environment variables are missing or invalid
+	exitUsage = 2
+	// exitUserNotFound is the exit value when a user or external identity isn't known to keybase
+	exitUserNotFound = 3
+	// exitKeyNotFound is the exit value when a user has no public key on keybase
+	exitKeyNotFound = 4
+	// exitAPIError is the exit value when the keybase API can't be reached or returns an error
+	exitAPIError = 5
+	// exitPolicyViolation is the exit value when a policy is violated: fingerprint pin, key validation, identity proofs
+	exitPolicyViolation = 6
+	// exitInterrupted is the exit value when the run is interrupted by SIGINT or SIGTERM, as shells do for SIGINT
+	exitInterrupted = 130
+)
+
+// exitCodeFor maps an error returned by the keybase package to its exit value
+func exitCodeFor(err error) int {
+
+	if err == nil {
+
+		return exitOK
+	}
+
+	switch errors.Cause(err) {
+	case context.Canceled:
+		return exitInterrupted
+	case context.DeadlineExceeded:
+		return exitAPIError
+	}
+
+	switch errors.Cause(err).(type) {
+	case keybase.ErrorUserNotFound:
+		return exitUserNotFound
+	case keybase.ErrorPKNotFound:
+		return exitKeyNotFound
+	case keybase.ErrorAPIStatus, keybase.ErrorHTTPStatus, keybase.ErrorCacheMiss:
+		return exitAPIError
+	case keybase.ErrorFingerprintMismatch, keybase.ErrorKeyInvalid, keybase.ErrorProofViolation:
+		return exitPolicyViolation
+	case *url.Error, net.Error, *json.SyntaxError, *json.UnmarshalTypeError:
+		return exitAPIError
+	}
+
+	return exitFailure
+}
+
+// firstFailure returns the exit value to keep when a failure with the given exit value happens
+// the first failure of a run wins, later ones don't override it
+func firstFailure(current, code int) int {
+
+	if current != exitOK {
+
+		return current
+	}
+	return code
+}
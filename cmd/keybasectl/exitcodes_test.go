@@ -0,0 +1,85 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stefancocora/keybasectl/cmd/keybasectl/keybase"
+)
+
+func TestExitCodeFor(t *testing.T) {
+
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{name: "no error", err: nil, want: exitOK},
+		{name: "user not found", err: keybase.ErrorUserNotFound{}, want: exitUserNotFound},
+		{name: "public key not found", err: keybase.ErrorPKNotFound{}, want: exitKeyNotFound},
+		{name: "keybase status", err: keybase.ErrorAPIStatus{Code: 602, Name: "RATE_LIMIT"}, want: exitAPIError},
+		{name: "http status", err: keybase.ErrorHTTPStatus{StatusCode: 502}, want: exitAPIError},
+		{name: "unreachable API", err: &url.Error{Op: "Get", URL: keybase.DefaultBaseURL, Err: errors.New("connection refused")}, want: exitAPIError},
+		{name: "malformed response", err: &json.SyntaxError{}, want: exitAPIError},
+		{name: "fingerprint mismatch", err: keybase.ErrorFingerprintMismatch{}, want: exitPolicyViolation},
+		{name: "invalid key", err: keybase.ErrorKeyInvalid{}, want: exitPolicyViolation},
+		{name: "proof violation", err: keybase.ErrorProofViolation{}, want: exitPolicyViolation},
+		{name: "wrapped error", err: errors.Wrap(keybase.ErrorPKNotFound{}, "export-keys"), want: exitKeyNotFound},
+		{name: "other error", err: errors.New("boom"), want: exitFailure},
+		{name: "offline cache miss", err: keybase.ErrorCacheMiss{}, want: exitAPIError},
+		{name: "interrupted", err: errors.Wrap(context.Canceled, "lookup"), want: exitInterrupted},
+		{name: "timed out", err: errors.Wrap(context.DeadlineExceeded, "lookup"), want: exitAPIError},
+	}
+
+	for _, tt := range tests {
+
+		if got := exitCodeFor(tt.err); got != tt.want {
+
+			t.Errorf("%s: expected exit value %d, got %d", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestFirstFailure(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		failures []int
+		want     int
+	}{
+		{name: "no failure", want: exitOK},
+		{name: "single failure", failures: []int{exitKeyNotFound}, want: exitKeyNotFound},
+		{name: "the first failure wins over a later one", failures: []int{exitUserNotFound, exitPolicyViolation}, want: exitUserNotFound},
+		{name: "a later failure doesn't override an earlier one of lower value", failures: []int{exitPolicyViolation, exitUserNotFound, exitAPIError}, want: exitPolicyViolation},
+		{name: "success doesn't reset a failure", failures: []int{exitAPIError, exitOK}, want: exitAPIError},
+	}
+
+	for _, tt := range tests {
+
+		code := exitOK
+		for _, f := range tt.failures {
+
+			code = firstFailure(code, f)
+		}
+		if code != tt.want {
+
+			t.Errorf("%s: expected exit value %d, got %d", tt.name, tt.want, code)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stefancocora/keybasectl/cmd/keybasectl/keybase"
+	log "github.com/stefancocora/keybasectl/internal/log"
+)
+
+// exportKeysCmd is the name of the subcommand writing the users' PGP public keys to disk
+const exportKeysCmd = "export-keys"
+
+// exportKeys fetches the primary PGP public key of every user and writes them
+// either as one <username>.asc file per user or as a single combined armored keyring
+// it returns the process exit value
+func exportKeys(args []string) int {
+
+	var ekDebug bool
+	var ekUsers userFlag
+	var ekAPI apiEndpointFlag
+	var outDir, keyring string
+	var timeout time.Duration
+	var cfgPath string
+	var exported, missing, failed []string
+
+	fs := flag.NewFlagSet(exportKeysCmd, flag.ExitOnError)
+	fs.BoolVar(&ekDebug, "debug", false, "turn on debugging")
+	fs.StringVar(&cfgPath, cfgName, "", cfgUsage)
+	fs.Var(&ekAPI, apiName, apiUsage)
+	fs.Var(&ekUsers, usName, usUsage)
+	fs.Var(&userFileFlag{users: &ekUsers}, usFileName, usFileUsage)
+	fs.StringVar(&outDir, "out-dir", "", "directory to write one <username>.asc file per user to")
+	fs.StringVar(&keyring, "keyring", "", "file to write a single armored keyring holding every user's key to, - for stdout")
+	fs.DurationVar(&timeout, timeoutName, 0, timeoutUsage)
+	fs.Usage = func() {
+
+		fmt.Fprintf(fs.Output(), "Usage: %s %s --user USERS (--out-dir DIR | --keyring FILE)\n\nWrite the users' primary PGP public keys as found on keybase\n\n", os.Args[0], exportKeysCmd)
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	cfg, errcfg := applyConfig(fs, cfgPath)
+	loggingSetup(ekDebug)
+	if errcfg != nil {
+
+		fmt.Fprintf(os.Stdout, "invalid configuration! flag: \"%s\": %v\n", cfgName, errcfg)
+		return exitUsage
+	}
+
+	if (outDir == "") == (keyring == "") {
+
+		fmt.Fprintf(os.Stdout, "exactly one of the flags \"%s\" or \"%s\" must be set\n", "out-dir", "keyring")
+		fs.Usage()
+		return exitUsage
+	}
+
+	users, erru := resolveUsers(ekUsers, cfg.users())
+	if erru != nil {
+
+		log.ErrorLog.Printf("%v", erru)
+		fmt.Fprintf(os.Stdout, "required flag or environment variable not set! flag: \"%s\", environmentVariable: \"%v\"\n", usName, usEnv)
+		return exitUsage
+	}
+
+	apiURL, errapi := resolveAPIEndpoint(ekAPI)
+	if errapi != nil {
+
+		log.ErrorLog.Printf("invalid keybase API endpoint: %v", errapi)
+		fmt.Fprintf(os.Stdout, "invalid keybase API endpoint! flag: \"%s\", environmentVariable: \"%v\": %v\n", apiName, apiEnv, errapi)
+		return exitUsage
+	}
+
+	kbc := keybase.NewClient(apiURL)
+	ctx, cancel := runContext(timeout)
+	defer cancel()
+
+	// step: lookup the users' public keys, users without a key or whose lookup failed are reported below
+	kr, errpkl := kbc.PubKeyLookup(ctx, users)
+	if errpkl != nil {
+
+		if !isPKNotFound(errpkl) && !isPartialLookup(errpkl) {
+
+			fmt.Fprintf(os.Stdout, "error : %s\n", errpkl.Error())
+			log.ErrorLog.Printf("error : %s", errpkl.Error())
+			return exitCodeFor(errpkl)
+		}
+	}
+
+	// step: write the keys out
+	var ring bytes.Buffer
+	for _, r := range kr {
+
+		if r.Err != nil {
+
+			log.ErrorLog.Printf("public key lookup of user %s failed: %v", r.Username, r.Err)
+			failed = append(failed, r.Username)
+			continue
+		}
+		bundle := r.PublicKeys.PrimaryPGPBundle()
+		if bundle == "" {
+
+			log.DebugLog.Printf("no PGP public key for user %s", r.Username)
+			missing = append(missing, r.Username)
+			continue
+		}
+		bundle = strings.TrimSpace(bundle) + "\n"
+
+		if outDir != "" {
+
+			if errw := writeKeyFile(outDir, r.Username, bundle); errw != nil {
+
+				fmt.Fprintf(os.Stdout, "error : %s\n", errw.Error())
+				log.ErrorLog.Printf("error : %s", errw.Error())
+				return exitFailure
+			}
+		} else {
+
+			ring.WriteString(bundle)
+		}
+		exported = append(exported, r.Username)
+	}
+
+	if keyring != "" && ring.Len() > 0 {
+
+		if errw := writeKeyring(keyring, ring.Bytes()); errw != nil {
+
+			fmt.Fprintf(os.Stdout, "error : %s\n", errw.Error())
+			log.ErrorLog.Printf("error : %s", errw.Error())
+			return exitFailure
+		}
+	}
+
+	// the summary goes to stderr when stdout holds the keyring
+	summary := os.Stdout
+	if keyring == "-" {
+
+		summary = os.Stderr
+	}
+	if len(exported) > 0 {
+
+		fmt.Fprintf(summary, "user(s): %v PGP public key exported\n", exported)
+	}
+	if len(missing) > 0 {
+
+		fmt.Fprintf(summary, "user(s): %v PGP public key not found during keybase public key lookup\n", missing)
+	}
+	if len(failed) > 0 {
+
+		fmt.Fprintf(summary, "user(s): %v keybase public key lookup failed: %v\n", failed, errors.Cause(errpkl))
+		return exitCodeFor(errpkl)
+	}
+	if len(missing) > 0 {
+
+		return exitKeyNotFound
+	}
+
+	return exitOK
+}
+
+// writeKeyFile writes the armored key of the user to <dir>/<username>.asc
+func writeKeyFile(dir, username, bundle string) error {
+
+	if errmk := os.MkdirAll(dir, 0755); errmk != nil {
+
+		return errors.Wrapf(errmk, "unable to create the output directory: %s", dir)
+	}
+
+	path := filepath.Join(dir, username+".asc")
+	log.DebugLog.Printf("writing the PGP public key of user %s to %s", username, path)
+	if errw := ioutil.WriteFile(path, []byte(bundle), 0644); errw != nil {
+
+		return errors.Wrapf(errw, "unable to write the PGP public key of user %s", username)
+	}
+
+	return nil
+}
+
+// writeKeyring writes the concatenated armored keys to path, - means stdout
+func writeKeyring(path string, ring []byte) error {
+
+	if path == "-" {
+
+		_, errw := os.Stdout.Write(ring)
+		return errw
+	}
+
+	log.DebugLog.Printf("writing the PGP keyring to %s", path)
+	if errw := ioutil.WriteFile(path, ring, 0644); errw != nil {
+
+		return errors.Wrapf(errw, "unable to write the PGP keyring: %s", path)
+	}
+
+	return nil
+}
@@ -0,0 +1,365 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stefancocora/keybasectl/cmd/keybasectl/keybase"
+	log "github.com/stefancocora/keybasectl/internal/log"
+)
+
+// the report formats supported by the --report flag
+const (
+	reportJUnit = "junit"
+)
+
+// reportFlag is the struct that get populated when the --report cli flag is provided
+// it's repeatable, every occurrence writes a report file: format=path
+type reportFlag struct {
+	set   bool
+	value map[string]string
+}
+
+func (rf *reportFlag) Set(val string) error {
+
+	parts := strings.SplitN(val, "=", 2)
+	if len(parts) != 2 || strings.TrimSpace(parts[1]) == "" {
+
+		return errors.Errorf("invalid report %q, expected format=path", val)
+	}
+
+	format := strings.ToLower(strings.TrimSpace(parts[0]))
+	if format != reportJUnit {
+
+		return errors.Errorf("unsupported report format %q, expected one of [%s]", parts[0], reportJUnit)
+	}
+	if rf.value == nil {
+
+		rf.value = make(map[string]string)
+	}
+	rf.value[format] = strings.TrimSpace(parts[1])
+	rf.set = true
+	return nil
+}
+
+func (rf *reportFlag) String() string {
+
+	return fmt.Sprintf("%v", rf.value)
+}
+
+// the names of the JUnit test suites, one per keybase lookup
+const (
+	junitUserSuite   = "keybase user lookup"
+	junitPubKeySuite = "keybase public key lookup"
+)
+
+// junitTestSuites is the root element of a JUnit XML report
+type junitTestSuites struct {
+	XMLName  xml.Name          `xml:"testsuites"`
+	Name     string            `xml:"name,attr"`
+	Tests    int               `xml:"tests,attr"`
+	Failures int               `xml:"failures,attr"`
+	Errors   int               `xml:"errors,attr"`
+	Skipped  int               `xml:"skipped,attr"`
+	Time     string            `xml:"time,attr"`
+	Suites   []*junitTestSuite `xml:"testsuite"`
+}
+
+// junitTestSuite groups the test cases of a single keybase lookup
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Errors    int             `xml:"errors,attr"`
+	Skipped   int             `xml:"skipped,attr"`
+	Time      string          `xml:"time,attr"`
+	Timestamp string          `xml:"timestamp,attr"`
+	Cases     []junitTestCase `xml:"testcase"`
+
+	elapsed time.Duration
+}
+
+// junitTestCase is the check of a single user, it passes unless it holds a failure, an error or is skipped
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitProblem `xml:"failure,omitempty"`
+	Error     *junitProblem `xml:"error,omitempty"`
+	Skipped   *junitProblem `xml:"skipped,omitempty"`
+}
+
+// junitProblem describes why a test case didn't pass
+type junitProblem struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr,omitempty"`
+	Text    string `xml:",chardata"`
+}
+
+// junitReport collects the outcome of the keybase lookups as JUnit test suites while the checks run
+type junitReport struct {
+	users  []string
+	suites []*junitTestSuite
+	// unchecked holds why the users the user lookup didn't pass aren't looked further up, when a run carries on without them
+	unchecked map[string]string
+}
+
+// addUserLookup records a test case per user for the outcome of the user lookup
+func (jr *junitReport) addUserLookup(users []string, results []keybase.UserResult, errl error, elapsed time.Duration) {
+
+	jr.users = keybase.NormaliseUsernames(users)
+
+	found := make(map[string]bool)
+	failed := make(map[string]error)
+	for _, r := range results {
+
+		found[r.Username] = r.Found
+		if r.Err != nil {
+
+			failed[r.Username] = r.Err
+		}
+	}
+
+	ts := &junitTestSuite{Name: junitUserSuite, elapsed: elapsed}
+	for _, u := range jr.users {
+
+		tc := junitTestCase{Name: u, ClassName: "keybasectl.user_lookup"}
+		switch {
+		case failed[u] != nil:
+			tc.Error = &junitProblem{Message: "keybase user lookup failed", Type: fmt.Sprintf("%T", errors.Cause(failed[u])), Text: failed[u].Error()}
+		case found[u]:
+		case isUserNotFound(errl):
+			tc.Failure = &junitProblem{Message: fmt.Sprintf("user %s not found during keybase lookup", u), Type: "keybase.ErrorUserNotFound", Text: errl.Error()}
+		case isPartialLookup(errl):
+			tc.Failure = &junitProblem{Message: fmt.Sprintf("user %s not found during keybase lookup", u), Type: "keybase.ErrorUserNotFound"}
+		case errl != nil:
+			tc.Error = &junitProblem{Message: "keybase user lookup failed", Type: fmt.Sprintf("%T", errors.Cause(errl)), Text: errl.Error()}
+		default:
+			tc.Failure = &junitProblem{Message: fmt.Sprintf("user %s not found during keybase lookup", u)}
+		}
+		switch {
+		case tc.Failure != nil:
+			jr.uncheck(u, "user not found")
+		case tc.Error != nil:
+			jr.uncheck(u, "the keybase user lookup failed")
+		}
+		ts.add(tc)
+	}
+	jr.suites = append(jr.suites, ts)
+}
+
+// uncheck records why the user won't be looked further up
+func (jr *junitReport) uncheck(username, reason string) {
+
+	if jr.unchecked == nil {
+
+		jr.unchecked = make(map[string]string)
+	}
+	jr.unchecked[username] = reason
+}
+
+// addPubKeyLookup records a test case per user for the outcome of the public key lookup
+// the users of the user lookup left out of the public key lookup, e.g. the roster members not found, are skipped
+func (jr *junitReport) addPubKeyLookup(users []string, results []keybase.PubKeyResult, errpkl error, elapsed time.Duration) {
+
+	looked := make(map[string]bool)
+	for _, u := range keybase.NormaliseUsernames(users) {
+
+		looked[u] = true
+	}
+	if jr.users == nil {
+
+		jr.users = keybase.NormaliseUsernames(users)
+	}
+
+	found := make(map[string]bool)
+	failed := make(map[string]error)
+	for _, r := range results {
+
+		found[r.Username] = r.Found
+		if r.Err != nil {
+
+			failed[r.Username] = r.Err
+		}
+	}
+
+	ts := &junitTestSuite{Name: junitPubKeySuite, elapsed: elapsed}
+	for _, u := range jr.users {
+
+		tc := junitTestCase{Name: u, ClassName: "keybasectl.pubkey_lookup"}
+		switch {
+		case !looked[u]:
+			tc.Skipped = &junitProblem{Message: jr.unchecked[u]}
+		case failed[u] != nil:
+			tc.Error = &junitProblem{Message: "keybase public key lookup failed", Type: fmt.Sprintf("%T", errors.Cause(failed[u])), Text: failed[u].Error()}
+		case found[u]:
+		case isPKNotFound(errpkl):
+			tc.Failure = &junitProblem{Message: fmt.Sprintf("user %s public key not found during keybase public key lookup", u), Type: "keybase.ErrorPKNotFound", Text: errpkl.Error()}
+		case isPartialLookup(errpkl):
+			tc.Failure = &junitProblem{Message: fmt.Sprintf("user %s public key not found during keybase public key lookup", u), Type: "keybase.ErrorPKNotFound"}
+		case errpkl != nil:
+			tc.Error = &junitProblem{Message: "keybase public key lookup failed", Type: fmt.Sprintf("%T", errors.Cause(errpkl)), Text: errpkl.Error()}
+		default:
+			tc.Failure = &junitProblem{Message: fmt.Sprintf("user %s public key not found during keybase public key lookup", u)}
+		}
+		ts.add(tc)
+	}
+	jr.suites = append(jr.suites, ts)
+}
+
+// add appends the test case to the suite and updates the suite counters
+func (ts *junitTestSuite) add(tc junitTestCase) {
+
+	ts.Tests++
+	switch {
+	case tc.Failure != nil:
+		ts.Failures++
+	case tc.Error != nil:
+		ts.Errors++
+	case tc.Skipped != nil:
+		ts.Skipped++
+	}
+	tc.Time = "0.000"
+	ts.Cases = append(ts.Cases, tc)
+}
+
+// skipUserLookup records the user lookup of every user as skipped
+func (jr *junitReport) skipUserLookup(users []string, reason string) {
+
+	jr.users = keybase.NormaliseUsernames(users)
+
+	ts := &junitTestSuite{Name: junitUserSuite}
+	for _, u := range jr.users {
+
+		ts.add(junitTestCase{Name: u, ClassName: "keybasectl.user_lookup", Skipped: &junitProblem{Message: reason}})
+	}
+	jr.suites = append(jr.suites, ts)
+}
+
+// skipPubKeyLookup records the public key check of every user as skipped
+func (jr *junitReport) skipPubKeyLookup(users []string, reason string) {
+
+	if jr.users == nil {
+
+		jr.users = keybase.NormaliseUsernames(users)
+	}
+
+	ts := &junitTestSuite{Name: junitPubKeySuite}
+	for _, u := range jr.users {
+
+		ts.add(junitTestCase{Name: u, ClassName: "keybasectl.pubkey_lookup", Skipped: &junitProblem{Message: reason}})
+	}
+	jr.suites = append(jr.suites, ts)
+}
+
+// interruptUserLookup records the user lookup of every user as an error, the run got cancelled before it completed
+func (jr *junitReport) interruptUserLookup(users []string, errctx error) {
+
+	jr.users = keybase.NormaliseUsernames(users)
+
+	ts := &junitTestSuite{Name: junitUserSuite}
+	for _, u := range jr.users {
+
+		ts.add(junitTestCase{Name: u, ClassName: "keybasectl.user_lookup", Error: interruptProblem(errctx)})
+	}
+	jr.suites = append(jr.suites, ts)
+}
+
+// interruptPubKeyLookup records the public key check of every user as an error, the run got cancelled before it completed
+func (jr *junitReport) interruptPubKeyLookup(users []string, errctx error) {
+
+	if jr.users == nil {
+
+		jr.users = keybase.NormaliseUsernames(users)
+	}
+
+	ts := &junitTestSuite{Name: junitPubKeySuite}
+	for _, u := range jr.users {
+
+		ts.add(junitTestCase{Name: u, ClassName: "keybasectl.pubkey_lookup", Error: interruptProblem(errctx)})
+	}
+	jr.suites = append(jr.suites, ts)
+}
+
+// interruptProblem describes a lookup that didn't complete because the run timed out or got cancelled
+func interruptProblem(errctx error) *junitProblem {
+
+	return &junitProblem{Message: interruptReason(errctx), Type: fmt.Sprintf("%T", errctx), Text: errctx.Error()}
+}
+
+// build assembles the JUnit document
+func (jr *junitReport) build() *junitTestSuites {
+
+	now := time.Now().UTC().Format("2006-01-02T15:04:05")
+	doc := &junitTestSuites{Name: "keybasectl", Suites: jr.suites}
+	var total time.Duration
+	for _, ts := range jr.suites {
+
+		ts.Time = fmt.Sprintf("%.3f", ts.elapsed.Seconds())
+		ts.Timestamp = now
+		doc.Tests += ts.Tests
+		doc.Failures += ts.Failures
+		doc.Errors += ts.Errors
+		doc.Skipped += ts.Skipped
+		total += ts.elapsed
+	}
+	doc.Time = fmt.Sprintf("%.3f", total.Seconds())
+
+	return doc
+}
+
+// write serialises the JUnit document to path
+func (jr *junitReport) write(path string) error {
+
+	b, errm := xml.MarshalIndent(jr.build(), "", "  ")
+	if errm != nil {
+
+		return errors.Wrap(errm, "unable to serialise the JUnit report")
+	}
+
+	log.DebugLog.Printf("writing the JUnit report to %s", path)
+	if errw := ioutil.WriteFile(path, append([]byte(xml.Header), append(b, '\n')...), 0644); errw != nil {
+
+		return errors.Wrapf(errw, "unable to write the JUnit report: %s", path)
+	}
+
+	return nil
+}
+
+// isUserNotFound reports whether the error is a keybase.ErrorUserNotFound
+func isUserNotFound(err error) bool {
+
+	_, ok := err.(keybase.ErrorUserNotFound)
+	return ok
+}
+
+// isPartialLookup reports whether the error is a keybase.ErrorPartialLookup, the users it didn't fail are looked up
+func isPartialLookup(err error) bool {
+
+	_, ok := err.(keybase.ErrorPartialLookup)
+	return ok
+}
+
+// isPKNotFound reports whether the error is a keybase.ErrorPKNotFound
+func isPKNotFound(err error) bool {
+
+	_, ok := err.(keybase.ErrorPKNotFound)
+	return ok
+}
@@ -0,0 +1,219 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stefancocora/keybasectl/cmd/keybasectl/keybase"
+)
+
+// outcomes summarises the test cases of a suite as user=outcome, the outcome is one of pass, failure, error or skipped
+func outcomes(ts *junitTestSuite) string {
+
+	var o []string
+	for _, tc := range ts.Cases {
+
+		outcome := "pass"
+		switch {
+		case tc.Failure != nil:
+			outcome = "failure"
+		case tc.Error != nil:
+			outcome = "error"
+		case tc.Skipped != nil:
+			outcome = "skipped"
+		}
+		o = append(o, tc.Name+"="+outcome)
+	}
+
+	return strings.Join(o, ",")
+}
+
+func TestJUnitReport(t *testing.T) {
+
+	users := []string{"Alice", "bob", "carol"}
+	userResults := []keybase.UserResult{{Username: "alice", Found: true}, {Username: "bob", Found: true}, {Username: "carol"}}
+	pubKeyResults := []keybase.PubKeyResult{{Username: "alice", Found: true}, {Username: "bob"}, {Username: "carol"}}
+
+	tests := []struct {
+		name    string
+		report  func(jr *junitReport)
+		suites  []string
+		summary [4]int // tests, failures, errors, skipped
+	}{
+		{
+			name: "every lookup",
+			report: func(jr *junitReport) {
+
+				jr.addUserLookup(users, userResults, keybase.ErrorUserNotFound{}, time.Second)
+				jr.addPubKeyLookup(users, pubKeyResults, keybase.ErrorPKNotFound{}, time.Second)
+			},
+			suites:  []string{"alice=pass,bob=pass,carol=failure", "alice=pass,bob=failure,carol=failure"},
+			summary: [4]int{6, 3, 0, 0},
+		},
+		{
+			name: "failed user lookup",
+			report: func(jr *junitReport) {
+
+				jr.addUserLookup(users, nil, keybase.ErrorAPIStatus{Code: 602, Name: "RATE_LIMIT"}, time.Second)
+				jr.skipPubKeyLookup(users, "the keybase user lookup failed")
+			},
+			suites:  []string{"alice=error,bob=error,carol=error", "alice=skipped,bob=skipped,carol=skipped"},
+			summary: [4]int{6, 0, 3, 3},
+		},
+		{
+			name: "partially failed lookups",
+			report: func(jr *junitReport) {
+
+				rateLimited := keybase.ErrorAPIStatus{Code: 602, Name: "RATE_LIMIT"}
+				partial := keybase.ErrorPartialLookup{Failed: map[string]error{"bob": rateLimited}}
+				jr.addUserLookup(users, []keybase.UserResult{{Username: "alice", Found: true}, {Username: "bob", Err: rateLimited}, {Username: "carol"}}, partial, time.Second)
+				jr.addPubKeyLookup(users, []keybase.PubKeyResult{{Username: "alice", Found: true}, {Username: "bob", Err: rateLimited}, {Username: "carol"}}, partial, time.Second)
+			},
+			suites:  []string{"alice=pass,bob=error,carol=failure", "alice=pass,bob=error,carol=failure"},
+			summary: [4]int{6, 2, 2, 0},
+		},
+		{
+			name: "users not found left out of the public key lookup",
+			report: func(jr *junitReport) {
+
+				jr.addUserLookup(users, userResults, keybase.ErrorUserNotFound{}, time.Second)
+				jr.addPubKeyLookup([]string{"alice", "bob"}, pubKeyResults[:2], keybase.ErrorPKNotFound{}, time.Second)
+			},
+			suites:  []string{"alice=pass,bob=pass,carol=failure", "alice=pass,bob=failure,carol=skipped"},
+			summary: [4]int{6, 2, 0, 1},
+		},
+		{
+			name: "lookups skipped",
+			report: func(jr *junitReport) {
+
+				jr.skipUserLookup(users, "an earlier check failed")
+				jr.skipPubKeyLookup(users, "an earlier check failed")
+			},
+			suites:  []string{"alice=skipped,bob=skipped,carol=skipped", "alice=skipped,bob=skipped,carol=skipped"},
+			summary: [4]int{6, 0, 0, 6},
+		},
+		{
+			name: "lookups interrupted",
+			report: func(jr *junitReport) {
+
+				jr.interruptUserLookup(users, context.DeadlineExceeded)
+				jr.interruptPubKeyLookup(users, context.Canceled)
+			},
+			suites:  []string{"alice=error,bob=error,carol=error", "alice=error,bob=error,carol=error"},
+			summary: [4]int{6, 0, 6, 0},
+		},
+	}
+
+	for _, tt := range tests {
+
+		var jr junitReport
+		tt.report(&jr)
+		doc := jr.build()
+
+		if len(doc.Suites) != len(tt.suites) {
+
+			t.Errorf("%s: expected %d suite(s), got %d", tt.name, len(tt.suites), len(doc.Suites))
+			continue
+		}
+		for i, ts := range doc.Suites {
+
+			if got := outcomes(ts); got != tt.suites[i] {
+
+				t.Errorf("%s: expected the %s test cases %s, got %s", tt.name, ts.Name, tt.suites[i], got)
+			}
+		}
+		if got := [4]int{doc.Tests, doc.Failures, doc.Errors, doc.Skipped}; got != tt.summary {
+
+			t.Errorf("%s: expected tests, failures, errors and skipped %v, got %v", tt.name, tt.summary, got)
+		}
+	}
+}
+
+func TestJUnitReportWrite(t *testing.T) {
+
+	var jr junitReport
+	jr.addUserLookup([]string{"alice", "carol"}, []keybase.UserResult{{Username: "alice", Found: true}, {Username: "carol"}}, keybase.ErrorUserNotFound{}, 1500*time.Millisecond)
+	jr.addPubKeyLookup([]string{"alice", "carol"}, []keybase.PubKeyResult{{Username: "alice", Found: true}, {Username: "carol"}}, keybase.ErrorPKNotFound{}, 500*time.Millisecond)
+
+	path := filepath.Join(t.TempDir(), "report.xml")
+	if errw := jr.write(path); errw != nil {
+
+		t.Fatalf("unexpected error: %v", errw)
+	}
+
+	b, errrf := ioutil.ReadFile(path)
+	if errrf != nil {
+
+		t.Fatalf("unexpected error: %v", errrf)
+	}
+	if !strings.HasPrefix(string(b), xml.Header) {
+
+		t.Errorf("expected the report to start with the XML header, got %.40q", b)
+	}
+
+	var doc junitTestSuites
+	if errun := xml.Unmarshal(b, &doc); errun != nil {
+
+		t.Fatalf("unable to parse the report: %v", errun)
+	}
+	if doc.Tests != 4 || doc.Failures != 2 || doc.Time != "2.000" {
+
+		t.Errorf("expected 4 tests, 2 failures in 2.000s, got %d tests, %d failures in %ss", doc.Tests, doc.Failures, doc.Time)
+	}
+	if len(doc.Suites) != 2 || doc.Suites[0].Name != junitUserSuite || doc.Suites[1].Name != junitPubKeySuite {
+
+		t.Fatalf("expected the user and public key suites, got %d suite(s)", len(doc.Suites))
+	}
+	if tc := doc.Suites[0].Cases[1]; tc.Name != "carol" || tc.Failure == nil || tc.Failure.Type != "keybase.ErrorUserNotFound" {
+
+		t.Errorf("expected a user not found failure for carol, got %+v", tc)
+	}
+}
+
+func TestReportFlag(t *testing.T) {
+
+	tests := []struct {
+		val  string
+		path string
+		err  bool
+	}{
+		{val: "junit=report.xml", path: "report.xml"},
+		{val: " JUnit = out/report.xml ", path: "out/report.xml"},
+		{val: "junit", err: true},
+		{val: "junit=", err: true},
+		{val: "tap=report.tap", err: true},
+	}
+
+	for _, tt := range tests {
+
+		var rf reportFlag
+		errs := rf.Set(tt.val)
+		if (errs != nil) != tt.err {
+
+			t.Errorf("Set(%q): expected error %v, got %v", tt.val, tt.err, errs)
+			continue
+		}
+		if rf.value[reportJUnit] != tt.path {
+
+			t.Errorf("Set(%q): expected the path %q, got %q", tt.val, tt.path, rf.value[reportJUnit])
+		}
+	}
+}
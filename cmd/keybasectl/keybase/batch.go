@@ -0,0 +1,190 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keybase
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/pkg/errors"
+	log "github.com/stefancocora/keybasectl/internal/log"
+)
+
+// ErrorPartialLookup is the error returned when some chunks of a lookup failed while the others were fetched
+// the users of the fetched chunks are returned along with it
+type ErrorPartialLookup struct {
+	err    error
+	errmsg string
+	// Failed maps every selector value of the failed chunks to the error of its chunk
+	Failed map[string]error
+}
+
+// Error implements the error interface for a type of ErrorPartialLookup
+func (pl ErrorPartialLookup) Error() string {
+
+	return pl.errmsg
+}
+
+// Cause returns the error of the first failed chunk, the lookup failure is classified by it
+func (pl ErrorPartialLookup) Cause() error {
+
+	return pl.err
+}
+
+// partialLookup returns the error of a lookup whose given values failed, first is the error of the first of them
+func partialLookup(sel Selector, values []string, failed map[string]error, first error) ErrorPartialLookup {
+
+	var epl ErrorPartialLookup
+	epl.err = first
+	epl.errmsg = fmt.Sprintf("lookup of %s %v failed: %v", sel, values, first)
+	epl.Failed = failed
+	return epl
+}
+
+// failedValues returns the selector values a partially failed lookup couldn't fetch, nil for any other error
+func failedValues(err error) map[string]error {
+
+	if epl, ok := err.(ErrorPartialLookup); ok {
+
+		return epl.Failed
+	}
+	return nil
+}
+
+// fetchBySelector uses the keybase API to fetch the users matching the given selector values with the requested fields
+// the values are split in chunks of at most ChunkSize values, fetched by at most Concurrency requests in flight
+// the returned users are keyed by the selector value they correlate with, values matching no user are absent
+// when some chunks fail, the users of the other chunks are returned with an ErrorPartialLookup holding the error of every failed value
+// when every chunk fails, the error of the first chunk is returned
+// no further chunk is fetched once the context is done, the chunks not fetched fail with the context error
+func (c *Client) fetchBySelector(ctx context.Context, sel Selector, values []string, fields ...string) (map[string]*User, error) {
+
+	if len(values) == 0 {
+
+		return nil, errors.Errorf("no %s to lookup", sel)
+	}
+
+	chunks := chunkValues(values, c.ChunkSize)
+	if len(chunks) == 1 {
+
+		return c.fetchChunk(ctx, sel, chunks[0], fields...)
+	}
+
+	workers := c.Concurrency
+	if workers < 1 {
+
+		workers = 1
+	}
+	if workers > len(chunks) {
+
+		workers = len(chunks)
+	}
+	log.DebugLog.Printf("fetching %d %s in %d chunk(s) with %d worker(s)", len(values), sel, len(chunks), workers)
+
+	fetched := make([]map[string]*User, len(chunks))
+	errs := make([]error, len(chunks))
+
+	var wg sync.WaitGroup
+	next := make(chan int)
+	for w := 0; w < workers; w++ {
+
+		wg.Add(1)
+		go func() {
+
+			defer wg.Done()
+			for i := range next {
+
+				// once the lookup got cancelled, the remaining chunks aren't worth a request
+				if errctx := ctx.Err(); errctx != nil {
+
+					errs[i] = errctx
+					continue
+				}
+
+				fetched[i], errs[i] = c.fetchChunk(ctx, sel, chunks[i], fields...)
+				if errs[i] != nil {
+
+					log.DebugLog.Printf("chunk %d/%d of %s failed: %v", i+1, len(chunks), sel, errs[i])
+				}
+			}
+		}()
+	}
+	for i := range chunks {
+
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	// step: merge the chunks in order so the outcome doesn't depend on the scheduling
+	users := make(map[string]*User)
+	failed := make(map[string]error)
+	var failedOrder []string
+	var first error
+	for i := range chunks {
+
+		if errs[i] != nil {
+
+			if first == nil {
+
+				first = errs[i]
+			}
+			for _, v := range chunks[i] {
+
+				failed[v] = errs[i]
+				failedOrder = append(failedOrder, v)
+			}
+			continue
+		}
+		for v, u := range fetched[i] {
+
+			users[v] = u
+		}
+	}
+
+	if first == nil {
+
+		return users, nil
+	}
+	if len(failedOrder) == len(values) {
+
+		return nil, first
+	}
+
+	return users, partialLookup(sel, failedOrder, failed, first)
+}
+
+// chunkValues splits the values in consecutive chunks of at most size values
+// a size below 1 keeps every value in a single chunk
+func chunkValues(values []string, size int) [][]string {
+
+	if size < 1 || len(values) <= size {
+
+		return [][]string{values}
+	}
+
+	var chunks [][]string
+	for start := 0; start < len(values); start += size {
+
+		end := start + size
+		if end > len(values) {
+
+			end = len(values)
+		}
+		chunks = append(chunks, values[start:end])
+	}
+
+	return chunks
+}
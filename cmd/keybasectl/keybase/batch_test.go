@@ -0,0 +1,224 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keybase
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+// lookupServer is a stand-in for the keybase user lookup API serving its users by username
+// a request holding any of the failing usernames fails as a whole with the keybase rate limit status
+type lookupServer struct {
+	users   map[string]*User
+	failing map[string]bool
+
+	mu       sync.Mutex
+	requests [][]string
+}
+
+func (ls *lookupServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	values := strings.Split(r.URL.Query().Get(string(SelectorUsername)), ",")
+	ls.mu.Lock()
+	ls.requests = append(ls.requests, values)
+	ls.mu.Unlock()
+
+	var resp struct {
+		Status Status  `json:"status"`
+		Them   []*User `json:"them"`
+	}
+	for _, v := range values {
+
+		if ls.failing[v] {
+
+			resp.Status = Status{Code: statusRateLimit, Name: "RATE_LIMIT", Desc: "rate limit exceeded"}
+			resp.Them = nil
+			break
+		}
+		resp.Them = append(resp.Them, ls.users[v])
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// requestCount returns the number of requests served so far
+func (ls *lookupServer) requestCount() int {
+
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	return len(ls.requests)
+}
+
+// newLookupServer starts a lookupServer serving the given usernames, the server is closed with the test
+func newLookupServer(t *testing.T, usernames []string, failing ...string) (*httptest.Server, *lookupServer) {
+
+	ls := &lookupServer{users: make(map[string]*User), failing: make(map[string]bool)}
+	for _, u := range usernames {
+
+		ls.users[u] = testUser(u)
+	}
+	for _, f := range failing {
+
+		ls.failing[f] = true
+	}
+
+	srv := httptest.NewServer(ls)
+	t.Cleanup(srv.Close)
+	return srv, ls
+}
+
+// testClient returns a client targeting the given base URL, it doesn't retry and looks a single value up per request
+func testClient(baseURL string) *Client {
+
+	c := NewClient(baseURL)
+	c.MaxRetries = 0
+	c.ChunkSize = 1
+	c.Concurrency = 2
+	return c
+}
+
+// usernames returns the sorted selector values of the fetched users
+func usernames(users map[string]*User) []string {
+
+	var values []string
+	for v := range users {
+
+		values = append(values, v)
+	}
+	sort.Strings(values)
+	return values
+}
+
+func TestFetchBySelectorChunks(t *testing.T) {
+
+	srv, ls := newLookupServer(t, []string{"alice", "bob", "carol"})
+	c := testClient(srv.URL)
+	c.ChunkSize = 2
+
+	users, errfs := c.fetchBySelector(context.Background(), SelectorUsername, []string{"alice", "bob", "carol", "dave"})
+	if errfs != nil {
+
+		t.Fatalf("unexpected error: %v", errfs)
+	}
+	if got := ls.requestCount(); got != 2 {
+
+		t.Errorf("expected 2 requests, got %d", got)
+	}
+	if got := strings.Join(usernames(users), ","); got != "alice,bob,carol" {
+
+		t.Errorf("expected users alice,bob,carol, got %s", got)
+	}
+}
+
+func TestFetchBySelectorPartialFailure(t *testing.T) {
+
+	srv, _ := newLookupServer(t, []string{"alice", "bob"}, "ratelimited")
+	c := testClient(srv.URL)
+
+	users, errfs := c.fetchBySelector(context.Background(), SelectorUsername, []string{"alice", "bob", "ratelimited"})
+	epl, ok := errfs.(ErrorPartialLookup)
+	if !ok {
+
+		t.Fatalf("expected an ErrorPartialLookup, got %T: %v", errfs, errfs)
+	}
+	if got := strings.Join(usernames(users), ","); got != "alice,bob" {
+
+		t.Errorf("expected the users of the successful chunks alice,bob, got %s", got)
+	}
+	if len(epl.Failed) != 1 || epl.Failed["ratelimited"] == nil {
+
+		t.Errorf("expected only ratelimited to fail, got %v", epl.Failed)
+	}
+	if ase, ok := errors.Cause(errfs).(ErrorAPIStatus); !ok || ase.Code != statusRateLimit {
+
+		t.Errorf("expected the rate limit status as the cause, got %T: %v", errors.Cause(errfs), errors.Cause(errfs))
+	}
+}
+
+func TestFetchBySelectorEveryChunkFails(t *testing.T) {
+
+	srv, _ := newLookupServer(t, nil, "ratelimited", "throttled")
+	c := testClient(srv.URL)
+
+	users, errfs := c.fetchBySelector(context.Background(), SelectorUsername, []string{"ratelimited", "throttled"})
+	if users != nil {
+
+		t.Errorf("expected no users, got %v", usernames(users))
+	}
+	if _, ok := errfs.(ErrorAPIStatus); !ok {
+
+		t.Errorf("expected the error of the first chunk, got %T: %v", errfs, errfs)
+	}
+}
+
+func TestFetchBySelectorCancelled(t *testing.T) {
+
+	srv, ls := newLookupServer(t, []string{"alice", "bob"})
+	c := testClient(srv.URL)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, errfs := c.fetchBySelector(ctx, SelectorUsername, []string{"alice", "bob"})
+	if errors.Cause(errfs) != context.Canceled {
+
+		t.Errorf("expected the context error, got %v", errfs)
+	}
+	if got := ls.requestCount(); got != 0 {
+
+		t.Errorf("expected no request once cancelled, got %d", got)
+	}
+}
+
+func TestChunkValues(t *testing.T) {
+
+	tests := []struct {
+		values []string
+		size   int
+		want   int
+	}{
+		{values: []string{"a", "b", "c"}, size: 0, want: 1},
+		{values: []string{"a", "b", "c"}, size: 3, want: 1},
+		{values: []string{"a", "b", "c"}, size: 2, want: 2},
+		{values: []string{"a", "b", "c"}, size: 1, want: 3},
+	}
+
+	for _, tt := range tests {
+
+		chunks := chunkValues(tt.values, tt.size)
+		if len(chunks) != tt.want {
+
+			t.Errorf("chunkValues(%v, %d): expected %d chunk(s), got %d", tt.values, tt.size, tt.want, len(chunks))
+		}
+		var joined []string
+		for _, c := range chunks {
+
+			joined = append(joined, c...)
+		}
+		if strings.Join(joined, ",") != strings.Join(tt.values, ",") {
+
+			t.Errorf("chunkValues(%v, %d): expected the values in order, got %v", tt.values, tt.size, chunks)
+		}
+	}
+}
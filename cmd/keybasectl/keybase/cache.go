@@ -0,0 +1,280 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keybase
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/stefancocora/keybasectl/internal/log"
+	"github.com/stefancocora/keybasectl/internal/version"
+)
+
+// ErrorCacheMiss is returned by an offline lookup when the cache doesn't hold a value
+type ErrorCacheMiss struct {
+	err    error
+	errmsg string
+}
+
+// Error implements the error interface for a type of ErrorCacheMiss
+func (cm ErrorCacheMiss) Error() string {
+
+	return cm.errmsg
+}
+
+// Cache stores the decoded user lookup responses in a local directory, one file per API endpoint, selector value and fields
+// it's safe to share the directory between concurrent processes, an entry is replaced atomically
+type Cache struct {
+	// Dir is the directory holding the cache entries, it's created on the first write
+	Dir string
+	// TTL is the time an entry is served for, offline lookups serve the expired entries too
+	TTL time.Duration
+}
+
+// cacheEntry is the on-disk representation of a cached lookup response
+// a nil User records that the value matched no keybase user
+type cacheEntry struct {
+	Key      string    `json:"key"`
+	StoredAt time.Time `json:"stored_at"`
+	User     *User     `json:"user"`
+}
+
+// NewCache returns a Cache storing its entries in dir
+// an empty dir stores them in the user cache directory, $XDG_CACHE_HOME/keybasectl on linux
+func NewCache(dir string, ttl time.Duration) (*Cache, error) {
+
+	if dir == "" {
+
+		ucd, errucd := os.UserCacheDir()
+		if errucd != nil {
+
+			return nil, errors.Wrap(errucd, "unable to find the user cache directory")
+		}
+		dir = filepath.Join(ucd, version.BinaryName)
+	}
+
+	return &Cache{Dir: dir, TTL: ttl}, nil
+}
+
+// cacheEndpoint normalises the base URL of the API endpoint the entries come from
+// the scheme and host are lowercased and the trailing slashes dropped so the spellings of an endpoint share their entries
+func cacheEndpoint(baseURL string) string {
+
+	if baseURL == "" {
+
+		baseURL = DefaultBaseURL
+	}
+
+	u, errp := url.Parse(baseURL)
+	if errp != nil {
+
+		return strings.TrimRight(baseURL, "/")
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	u.Path = strings.TrimRight(u.Path, "/")
+	return u.String()
+}
+
+// cacheKey identifies the response of the given API endpoint to a lookup of a single selector value with the given fields
+// the endpoint is part of the key, the entries of an endpoint are never served for another
+func cacheKey(endpoint string, sel Selector, value string, fields []string) string {
+
+	fs := append([]string(nil), fields...)
+	sort.Strings(fs)
+	return endpoint + "?" + string(sel) + "=" + value + "&fields=" + strings.Join(fs, ",")
+}
+
+// path returns the file holding the entry of the given key
+func (ca *Cache) path(key string) string {
+
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(ca.Dir, hex.EncodeToString(sum[:])+".json")
+}
+
+// get returns the cached user of the given key, ok is false when there's no usable entry
+// the returned user is nil when the value is cached as matching no keybase user
+func (ca *Cache) get(key string, offline bool) (u *User, ok bool) {
+
+	b, errr := ioutil.ReadFile(ca.path(key))
+	if errr != nil {
+
+		if !os.IsNotExist(errr) {
+
+			log.DebugLog.Printf("unable to read the cache entry of %s: %v", key, errr)
+		}
+		return nil, false
+	}
+
+	var e cacheEntry
+	if errDec := json.Unmarshal(b, &e); errDec != nil || e.Key != key {
+
+		log.DebugLog.Printf("ignoring the unreadable cache entry of %s", key)
+		return nil, false
+	}
+
+	age := time.Since(e.StoredAt)
+	if age > ca.TTL {
+
+		if !offline {
+
+			log.DebugLog.Printf("cache entry of %s expired %s ago", key, age-ca.TTL)
+			return nil, false
+		}
+		log.DebugLog.Printf("offline, serving the cache entry of %s stored %s ago despite its TTL", key, age)
+	}
+
+	return e.User, true
+}
+
+// put stores the user of the given key, a nil user records that the value matched no keybase user
+func (ca *Cache) put(key string, u *User) error {
+
+	b, errm := json.Marshal(cacheEntry{Key: key, StoredAt: time.Now().UTC(), User: u})
+	if errm != nil {
+
+		return errors.Wrapf(errm, "unable to serialise the cache entry of %s", key)
+	}
+
+	if errmk := os.MkdirAll(ca.Dir, 0700); errmk != nil {
+
+		return errors.Wrapf(errmk, "unable to create the cache directory %s", ca.Dir)
+	}
+
+	// step: write aside and rename so a concurrent reader never sees a partial entry
+	tmp, errt := ioutil.TempFile(ca.Dir, ".entry-")
+	if errt != nil {
+
+		return errors.Wrapf(errt, "unable to create a cache entry in %s", ca.Dir)
+	}
+	_, errw := tmp.Write(b)
+	errc := tmp.Close()
+	if errw == nil {
+
+		errw = errc
+	}
+	if errw == nil {
+
+		errw = os.Rename(tmp.Name(), ca.path(key))
+	}
+	if errw != nil {
+
+		os.Remove(tmp.Name())
+		return errors.Wrapf(errw, "unable to write the cache entry of %s", key)
+	}
+
+	return nil
+}
+
+// fetchCached fetches the users matching the given selector values, serving the values held by the client cache
+// the values missing from the cache are fetched from the keybase API and stored, an offline client fails on them instead
+// the values of the chunks that failed aren't stored, they're returned in the ErrorPartialLookup along with the other users
+func (c *Client) fetchCached(ctx context.Context, sel Selector, values []string, fields ...string) (map[string]*User, error) {
+
+	if c.Offline && c.Cache == nil {
+
+		return nil, ErrorCacheMiss{errmsg: "offline lookups need a cache"}
+	}
+	if c.Cache == nil || len(values) == 0 {
+
+		return c.fetchBySelector(ctx, sel, values, fields...)
+	}
+
+	// the cache is keyed by the endpoint and the fields actually requested from the API
+	endpoint := cacheEndpoint(c.BaseURL)
+	fields = sel.fields(fields)
+
+	users := make(map[string]*User)
+	var missing []string
+	for _, v := range values {
+
+		u, ok := c.Cache.get(cacheKey(endpoint, sel, v, fields), c.Offline)
+		switch {
+		case !ok:
+			missing = append(missing, v)
+		case u != nil:
+			users[v] = u
+		}
+	}
+	log.DebugLog.Printf("%d/%d %s served from the cache", len(values)-len(missing), len(values), sel)
+
+	if len(missing) == 0 {
+
+		return users, nil
+	}
+	if c.Offline {
+
+		var ecm ErrorCacheMiss
+		ecm.errmsg = fmt.Sprintf("offline and %s %v not cached", sel, missing)
+		if len(missing) == len(values) {
+
+			return nil, ecm
+		}
+		// the values served from the cache are still reported
+		failed := make(map[string]error)
+		for _, v := range missing {
+
+			failed[v] = ecm
+		}
+		return users, partialLookup(sel, missing, failed, ecm)
+	}
+
+	fetched, errfs := c.fetchBySelector(ctx, sel, missing, fields...)
+	failed := failedValues(errfs)
+	if errfs != nil && failed == nil {
+
+		if len(missing) == len(values) {
+
+			return nil, errfs
+		}
+		// the values served from the cache are still reported
+		failed = make(map[string]error)
+		for _, v := range missing {
+
+			failed[v] = errfs
+		}
+		errfs = partialLookup(sel, missing, failed, errfs)
+	}
+
+	// step: store every fetched value, a value matching no user is cached as such
+	for _, v := range missing {
+
+		if _, ok := failed[v]; ok {
+
+			continue
+		}
+		u := fetched[v]
+		if errp := c.Cache.put(cacheKey(endpoint, sel, v, fields), u); errp != nil {
+
+			log.DebugLog.Printf("not caching %s %s: %v", sel, v, errp)
+		}
+		if u != nil {
+
+			users[v] = u
+		}
+	}
+
+	return users, errfs
+}
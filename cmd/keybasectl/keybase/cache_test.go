@@ -0,0 +1,194 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keybase
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestFetchCached(t *testing.T) {
+
+	srv, ls := newLookupServer(t, []string{"alice"})
+	c := testClient(srv.URL)
+	c.Cache = &Cache{Dir: t.TempDir(), TTL: time.Hour}
+
+	for i := 0; i < 2; i++ {
+
+		users, errfc := c.fetchCached(context.Background(), SelectorUsername, []string{"alice", "ghost"})
+		if errfc != nil {
+
+			t.Fatalf("lookup %d: unexpected error: %v", i+1, errfc)
+		}
+		if got := strings.Join(usernames(users), ","); got != "alice" {
+
+			t.Errorf("lookup %d: expected user alice, got %s", i+1, got)
+		}
+	}
+
+	// the unknown user is cached as such, the second lookup doesn't send a request
+	if got := ls.requestCount(); got != 2 {
+
+		t.Errorf("expected a request per value on the first lookup only, got %d request(s)", got)
+	}
+}
+
+func TestFetchCachedExpired(t *testing.T) {
+
+	srv, ls := newLookupServer(t, []string{"alice"})
+	c := testClient(srv.URL)
+	c.Cache = &Cache{Dir: t.TempDir(), TTL: 0}
+
+	for i := 0; i < 2; i++ {
+
+		if _, errfc := c.fetchCached(context.Background(), SelectorUsername, []string{"alice"}); errfc != nil {
+
+			t.Fatalf("lookup %d: unexpected error: %v", i+1, errfc)
+		}
+	}
+
+	if got := ls.requestCount(); got != 2 {
+
+		t.Errorf("expected the expired entry to be fetched again, got %d request(s)", got)
+	}
+}
+
+func TestFetchCachedPerEndpoint(t *testing.T) {
+
+	dir := t.TempDir()
+	srv1, _ := newLookupServer(t, []string{"alice"})
+	srv2, ls2 := newLookupServer(t, []string{"alice"})
+	ls2.users["alice"].ID = "other-id"
+
+	for _, srv := range []string{srv1.URL, srv2.URL} {
+
+		c := testClient(srv)
+		c.Cache = &Cache{Dir: dir, TTL: time.Hour}
+		if _, errfc := c.fetchCached(context.Background(), SelectorUsername, []string{"alice"}); errfc != nil {
+
+			t.Fatalf("%s: unexpected error: %v", srv, errfc)
+		}
+	}
+
+	if got := ls2.requestCount(); got != 1 {
+
+		t.Errorf("expected the entry of another endpoint not to be served, got %d request(s)", got)
+	}
+
+	c := testClient(srv2.URL + "/")
+	c.Cache = &Cache{Dir: dir, TTL: time.Hour}
+	c.Offline = true
+	users, errfc := c.fetchCached(context.Background(), SelectorUsername, []string{"alice"})
+	if errfc != nil {
+
+		t.Fatalf("unexpected error: %v", errfc)
+	}
+	if users["alice"].ID != "other-id" {
+
+		t.Errorf("expected the entry of the same endpoint, got user id %s", users["alice"].ID)
+	}
+}
+
+func TestFetchCachedOffline(t *testing.T) {
+
+	srv, ls := newLookupServer(t, []string{"alice", "bob"})
+	c := testClient(srv.URL)
+	c.Cache = &Cache{Dir: t.TempDir(), TTL: time.Hour}
+
+	if _, errfc := c.fetchCached(context.Background(), SelectorUsername, []string{"alice"}); errfc != nil {
+
+		t.Fatalf("unexpected error: %v", errfc)
+	}
+
+	c.Offline = true
+	c.Cache.TTL = 0
+	_, errfc := c.fetchCached(context.Background(), SelectorUsername, []string{"bob"})
+	if _, ok := errfc.(ErrorCacheMiss); !ok {
+
+		t.Errorf("expected an ErrorCacheMiss, got %T: %v", errfc, errfc)
+	}
+
+	// the expired entries are served offline, the values not cached fail alone
+	users, errfc := c.fetchCached(context.Background(), SelectorUsername, []string{"alice", "bob"})
+	epl, ok := errfc.(ErrorPartialLookup)
+	if !ok {
+
+		t.Fatalf("expected an ErrorPartialLookup, got %T: %v", errfc, errfc)
+	}
+	if got := strings.Join(usernames(users), ","); got != "alice" {
+
+		t.Errorf("expected the cached user alice, got %s", got)
+	}
+	if _, ok := errors.Cause(epl.Failed["bob"]).(ErrorCacheMiss); !ok || len(epl.Failed) != 1 {
+
+		t.Errorf("expected only bob to miss the cache, got %v", epl.Failed)
+	}
+	if got := ls.requestCount(); got != 1 {
+
+		t.Errorf("expected no request offline, got %d request(s)", got-1)
+	}
+}
+
+func TestFetchCachedPartialFailure(t *testing.T) {
+
+	srv, ls := newLookupServer(t, []string{"alice"}, "ratelimited")
+	c := testClient(srv.URL)
+	c.Cache = &Cache{Dir: t.TempDir(), TTL: time.Hour}
+
+	users, errfc := c.fetchCached(context.Background(), SelectorUsername, []string{"alice", "ratelimited"})
+	if _, ok := errfc.(ErrorPartialLookup); !ok {
+
+		t.Fatalf("expected an ErrorPartialLookup, got %T: %v", errfc, errfc)
+	}
+	if got := strings.Join(usernames(users), ","); got != "alice" {
+
+		t.Errorf("expected user alice, got %s", got)
+	}
+
+	// the failed value isn't cached, it's looked up again
+	delete(ls.failing, "ratelimited")
+	if _, errfc := c.fetchCached(context.Background(), SelectorUsername, []string{"alice", "ratelimited"}); errfc != nil {
+
+		t.Fatalf("unexpected error: %v", errfc)
+	}
+	if got := ls.requestCount(); got != 3 {
+
+		t.Errorf("expected the failed value only to be fetched again, got %d request(s)", got)
+	}
+}
+
+func TestCacheEndpoint(t *testing.T) {
+
+	tests := []struct {
+		baseURL string
+		want    string
+	}{
+		{baseURL: "", want: DefaultBaseURL},
+		{baseURL: "https://keybase.io", want: "https://keybase.io"},
+		{baseURL: "HTTPS://Keybase.IO/", want: "https://keybase.io"},
+		{baseURL: "http://127.0.0.1:8080/api//", want: "http://127.0.0.1:8080/api"},
+	}
+
+	for _, tt := range tests {
+
+		if got := cacheEndpoint(tt.baseURL); got != tt.want {
+
+			t.Errorf("cacheEndpoint(%q): expected %q, got %q", tt.baseURL, tt.want, got)
+		}
+	}
+}
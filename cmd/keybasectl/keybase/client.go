@@ -0,0 +1,271 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keybase
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/stefancocora/keybasectl/internal/log"
+	"github.com/stefancocora/keybasectl/internal/version"
+)
+
+// DefaultBaseURL is the base URL of the keybase production API
+const DefaultBaseURL = "https://keybase.io"
+
+// StagingBaseURL is the base URL of the keybase staging API
+const StagingBaseURL = "https://stage0.keybase.io"
+
+// namedEndpoints maps the friendly API endpoint names to their base URLs
+var namedEndpoints = map[string]string{
+	"production": DefaultBaseURL,
+	"staging":    StagingBaseURL,
+}
+
+// DefaultTimeout is the default timeout applied to every request made by a Client
+const DefaultTimeout = 30 * time.Second
+
+// DefaultUserAgent is the User-Agent header sent by a Client unless overridden
+const DefaultUserAgent = version.BinaryName
+
+// DefaultChunkSize is the default maximum number of values sent in a single user lookup request
+const DefaultChunkSize = 50
+
+// DefaultConcurrency is the default maximum number of user lookup requests in flight at once
+const DefaultConcurrency = 4
+
+// userLookupPath is the path of the keybase user lookup API, relative to the base URL
+const userLookupPath = "/_/api/1.0/user/lookup.json"
+
+// Client talks to the keybase API
+// the zero value is not usable, use NewClient to get one with sane defaults
+type Client struct {
+	// BaseURL is the scheme and host of the keybase API, e.g. https://keybase.io
+	BaseURL string
+	// HTTPClient is the http client used for every request
+	HTTPClient *http.Client
+	// UserAgent is sent as the User-Agent header on every request
+	UserAgent string
+	// Timeout bounds every request, it's applied on top of any HTTPClient timeout
+	Timeout time.Duration
+	// ChunkSize caps the number of values sent in a single user lookup request, larger lookups are split
+	ChunkSize int
+	// Concurrency caps the number of user lookup requests in flight at once
+	Concurrency int
+	// MaxRetries is the number of times a request failing with a transient error is retried, 0 disables the retries
+	MaxRetries int
+	// RetryBaseDelay is the delay before the first retry, it doubles on every retry and is jittered, 0 retries at once
+	RetryBaseDelay time.Duration
+	// RetryMaxDelay caps the delay between two attempts, a longer Retry-After fails the request
+	RetryMaxDelay time.Duration
+	// RateLimit caps the number of requests sent per second, 0 disables the rate limiting
+	RateLimit float64
+	// Cache serves the user lookups it holds without a request, nil disables the caching
+	Cache *Cache
+	// Offline fails the lookups the Cache doesn't hold instead of sending a request
+	Offline bool
+
+	limiterOnce sync.Once
+	limiter     *rateLimiter
+}
+
+// NewClient returns a Client targeting the given base URL
+// an empty baseURL targets the keybase production API
+func NewClient(baseURL string) *Client {
+
+	if baseURL == "" {
+
+		baseURL = DefaultBaseURL
+	}
+
+	return &Client{
+		BaseURL:        baseURL,
+		HTTPClient:     &http.Client{},
+		UserAgent:      DefaultUserAgent,
+		Timeout:        DefaultTimeout,
+		ChunkSize:      DefaultChunkSize,
+		Concurrency:    DefaultConcurrency,
+		MaxRetries:     DefaultMaxRetries,
+		RetryBaseDelay: DefaultRetryBaseDelay,
+		RetryMaxDelay:  DefaultRetryMaxDelay,
+	}
+}
+
+// ResolveBaseURL turns an API endpoint target into a base URL usable by NewClient
+// the target is either a named endpoint ("production", "staging") or an absolute http(s) URL
+func ResolveBaseURL(target string) (string, error) {
+
+	if target == "" {
+
+		return DefaultBaseURL, nil
+	}
+
+	if bu, ok := namedEndpoints[strings.ToLower(target)]; ok {
+
+		return bu, nil
+	}
+
+	u, errp := url.Parse(target)
+	if errp != nil {
+
+		return "", errors.Wrapf(errp, "unable to parse the keybase API endpoint: %s", target)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+
+		return "", errors.Errorf("keybase API endpoint %q must be one of [production staging] or an http(s) URL", target)
+	}
+	if u.Host == "" {
+
+		return "", errors.Errorf("keybase API endpoint %q is missing a host", target)
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+
+		return "", errors.Errorf("keybase API endpoint %q must not contain a query or a fragment", target)
+	}
+
+	return strings.TrimSuffix(u.String(), "/"), nil
+}
+
+// httpClient returns the http client to use, a default one when HTTPClient is unset
+func (c *Client) httpClient() *http.Client {
+
+	hc := c.HTTPClient
+	if hc == nil {
+
+		hc = &http.Client{}
+	}
+
+	return hc
+}
+
+// get issues a GET request against the given API path and returns the response body
+// requests failing with a transient error are retried, see MaxRetries, until the context is done
+func (c *Client) get(ctx context.Context, path string, query url.Values) ([]byte, error) {
+
+	u, errp := url.Parse(strings.TrimSuffix(c.BaseURL, "/") + path)
+	if errp != nil {
+
+		return nil, errors.Wrapf(errp, "unable to parse the keybase API url from base url: %s", c.BaseURL)
+	}
+	u.RawQuery = query.Encode()
+
+	for attempt := 0; ; attempt++ {
+
+		respb, errget := c.getOnce(ctx, u)
+		if errget == nil {
+
+			return respb, nil
+		}
+		if errctx := ctx.Err(); errctx != nil {
+
+			return nil, errctx
+		}
+
+		delay, retry := c.retryDelay(errget, attempt)
+		if !retry {
+
+			return nil, errget
+		}
+		log.DebugLog.Printf("attempt %d/%d against %s failed, retrying in %s: %v", attempt+1, c.MaxRetries+1, u, delay, errget)
+		if errsl := sleep(ctx, delay); errsl != nil {
+
+			return nil, errsl
+		}
+	}
+}
+
+// getOnce issues a single GET request against the given url and returns the response body
+// the request is bounded by Timeout on top of the context
+func (c *Client) getOnce(ctx context.Context, u *url.URL) ([]byte, error) {
+
+	if errth := c.throttle(ctx); errth != nil {
+
+		return nil, errth
+	}
+
+	if c.Timeout > 0 {
+
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+
+	req, errnr := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if errnr != nil {
+
+		return nil, errors.Wrapf(errnr, "unable to build the request for url: %s", u)
+	}
+	if c.UserAgent != "" {
+
+		req.Header.Set("User-Agent", c.UserAgent)
+	}
+
+	log.DebugLog.Printf("targeting keybase API url: %s", u)
+	res, errdo := c.httpClient().Do(req)
+	if errdo != nil {
+
+		return nil, errdo
+	}
+	defer res.Body.Close()
+
+	respb, errRA := ioutil.ReadAll(res.Body)
+	if errRA != nil {
+
+		return nil, errRA
+	}
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+
+		log.DebugLog.Printf("unexpected http status: %s, response body: %s", res.Status, respb)
+		return nil, httpStatusError(res, respb)
+	}
+
+	// keybase may rate limit with a 2xx response, the status block tells
+	if ase, ok := httpStatusError(res, respb).(ErrorAPIStatus); ok && ase.Code == statusRateLimit {
+
+		log.DebugLog.Printf("rate limited by keybase, response body: %s", respb)
+		return nil, ase
+	}
+
+	return respb, nil
+}
+
+// httpStatusError builds the error for a failed response
+// keybase usually explains failures in a status block, which is preferred over the bare http status
+func httpStatusError(res *http.Response, respb []byte) error {
+
+	var statusResponse struct {
+		Status *Status `json:"status"`
+	}
+
+	if errDec := json.Unmarshal(respb, &statusResponse); errDec == nil && statusResponse.Status != nil && statusResponse.Status.Code != statusOK {
+
+		return ErrorAPIStatus{
+			Code:       statusResponse.Status.Code,
+			Name:       statusResponse.Status.Name,
+			Desc:       statusResponse.Status.Desc,
+			HTTPStatus: res.StatusCode,
+			RetryAfter: parseRetryAfter(res.Header.Get("Retry-After")),
+		}
+	}
+
+	return ErrorHTTPStatus{StatusCode: res.StatusCode, Status: res.Status, RetryAfter: parseRetryAfter(res.Header.Get("Retry-After"))}
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keybase
+
+import (
+	"context"
+
+	log "github.com/stefancocora/keybasectl/internal/log"
+)
+
+// CombinedResult holds the outcome of the user, public key and identity proofs lookups of the same users
+// all of them are fed from a single keybase API response, so they can't disagree with each other
+type CombinedResult struct {
+	// Users is the outcome of the user lookup, see UserLookup
+	Users []UserResult
+	// UsersErr is an ErrorUserNotFound when any user is unknown to keybase, an ErrorPartialLookup when any user couldn't be fetched
+	UsersErr error
+	// PubKeys is the outcome of the public key lookup, see PubKeyLookup
+	PubKeys []PubKeyResult
+	// PubKeysErr is an ErrorPKNotFound when any user has no primary public key, an ErrorPartialLookup when any user couldn't be fetched
+	PubKeysErr error
+	// Proofs is the outcome of the identity proofs lookup, see ProofsLookup, it's only filled in when asked for
+	Proofs []ProofsResult
+	// ProofsErr is an ErrorUserNotFound when any user is unknown to keybase, an ErrorPartialLookup when any user couldn't be fetched
+	ProofsErr error
+
+	users      map[string]*User
+	errfu      error // the error of a partially failed fetch of the users
+	withProofs bool
+}
+
+// CombinedLookup is used to lookup users, their public keys and optionally their identity proofs using the keybase API
+// the fields of every lookup are requested at once, a single request is made per chunk of users
+// the returned error is only set when the lookup itself fails, users and public keys not found are reported in the result
+// so are the users of the chunks that failed while others were fetched
+func (c *Client) CombinedLookup(ctx context.Context, username []string, withProofs bool) (*CombinedResult, error) {
+
+	cr := CombinedResult{withProofs: withProofs}
+
+	username = NormaliseUsernames(username)
+	log.DebugLog.Printf("combined lookup of username(s): %v, with proofs: %v", username, withProofs)
+
+	fields := []string{"public_keys"}
+	if withProofs {
+
+		fields = append(fields, "proofs_summary")
+	}
+
+	users, errfu := c.fetchUsers(ctx, username, fields...)
+	if errfu != nil && failedValues(errfu) == nil {
+
+		return nil, errfu
+	}
+
+	cr.users = users
+	cr.errfu = errfu
+	cr.build(username)
+
+	return &cr, nil
+}
+
+// Narrow returns the outcome of the lookups restricted to the given users, without another request
+// users that weren't part of the lookup are reported as not found
+func (cr *CombinedResult) Narrow(username []string) *CombinedResult {
+
+	narrowed := CombinedResult{users: cr.users, errfu: cr.errfu, withProofs: cr.withProofs}
+	narrowed.build(NormaliseUsernames(username))
+
+	return &narrowed
+}
+
+// build fills in the outcome of every lookup of the given usernames from the fetched users
+func (cr *CombinedResult) build(username []string) {
+
+	cr.Users, cr.UsersErr = userResults(username, cr.users, cr.errfu)
+	cr.PubKeys, cr.PubKeysErr = pubKeyResults(username, cr.users, cr.errfu)
+	if cr.withProofs {
+
+		cr.Proofs, cr.ProofsErr = proofsResults(username, cr.users, cr.errfu)
+	}
+}
@@ -14,13 +14,16 @@ limitations under the License.
 package keybase
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"net/http"
+	"net/url"
 	"os"
 	"strings"
+	"time"
 
+	"github.com/pkg/errors"
 	log "github.com/stefancocora/keybasectl/internal/log"
 )
 
@@ -46,9 +49,73 @@ func (pknf ErrorPKNotFound) Error() string {
 	return pknf.errmsg
 }
 
-var kbdebug bool
+// ErrorFingerprintMismatch is the error returned when a user's key fingerprint doesn't match the pinned one
+type ErrorFingerprintMismatch struct {
+	err        error
+	errmsg     string
+	Mismatches []FingerprintMismatch
+}
+
+// Error implements the error interface for a type of ErrorFingerprintMismatch
+func (fm ErrorFingerprintMismatch) Error() string {
+	return fm.errmsg
+}
+
+// FingerprintMismatch describes a user whose key fingerprint doesn't match the pinned one
+type FingerprintMismatch struct {
+	// Username is the normalised username
+	Username string
+	// Expected is the pinned fingerprint
+	Expected string
+	// Actual is the fingerprint returned by keybase, empty when the user has no key
+	Actual string
+}
+
+// ErrorAPIStatus is the error returned when the keybase API reports a failure in its status block
+type ErrorAPIStatus struct {
+	// Code is the keybase status code, 0 means success
+	Code int
+	// Name is the keybase status name, e.g. INPUT_ERROR, RATE_LIMIT
+	Name string
+	// Desc is the human readable description of the failure
+	Desc string
+	// HTTPStatus is the HTTP status code of the response carrying the status block
+	HTTPStatus int
+	// RetryAfter is the delay asked for by the Retry-After header of the response, 0 when there's none
+	RetryAfter time.Duration
+}
 
-var keybaseUserLookupURL = "https://keybase.io/_/api/1.0/user/lookup.json?usernames="
+// Error implements the error interface for a type of ErrorAPIStatus
+func (as ErrorAPIStatus) Error() string {
+
+	msg := fmt.Sprintf("keybase API error: code=%d name=%s", as.Code, as.Name)
+	if as.Desc != "" {
+
+		msg = fmt.Sprintf("%s desc=%q", msg, as.Desc)
+	}
+	if as.HTTPStatus != 0 {
+
+		msg = fmt.Sprintf("%s httpstatus=%d", msg, as.HTTPStatus)
+	}
+	return msg
+}
+
+// ErrorHTTPStatus is the error returned when the keybase API answers with a non-2xx HTTP status and no status block
+type ErrorHTTPStatus struct {
+	// StatusCode is the HTTP status code, e.g. 502
+	StatusCode int
+	// Status is the HTTP status line, e.g. "502 Bad Gateway"
+	Status string
+	// RetryAfter is the delay asked for by the Retry-After header of the response, 0 when there's none
+	RetryAfter time.Duration
+}
+
+// Error implements the error interface for a type of ErrorHTTPStatus
+func (hs ErrorHTTPStatus) Error() string {
+	return fmt.Sprintf("keybase API error: unexpected http status %s", hs.Status)
+}
+
+var kbdebug bool
 
 // DebugFlag holds the value from the main pkg of the debug flag
 type DebugFlag struct {
@@ -77,6 +144,24 @@ type Status struct {
 	Name string `json:"name"`
 }
 
+// statusOK is the keybase status code denoting a successful API call
+const statusOK = 0
+
+// checkStatus turns a keybase API status block into an error when it reports a failure
+func checkStatus(s *Status) error {
+
+	if s == nil {
+
+		return errors.New("keybase API error: response is missing the status block")
+	}
+	if s.Code != statusOK {
+
+		return ErrorAPIStatus{Code: s.Code, Name: s.Name, Desc: s.Desc}
+	}
+
+	return nil
+}
+
 // User contains information regarding a user coming from the "them" response from the keybase API
 type User struct {
 	ID     string `json:"id"`
@@ -84,7 +169,8 @@ type User struct {
 	// Invitations InvitationStats `json:"invitation_stats"`
 	// Profile     Profile         `json:"profile"`
 	// Emails      Emails          `json:"emails"`
-	// PublicKeys  map[string]*Key `json:"public_keys"`
+	PublicKeys    *PublicKeys    `json:"public_keys"`
+	ProofsSummary *ProofsSummary `json:"proofs_summary"`
 	// PrivateKeys map[string]*Key `json:"private_keys"`
 }
 
@@ -114,9 +200,64 @@ type Key struct {
 	KeyID       string  `json:"kid"`
 	Fingerprint string  `json:"key_fingerprint"`
 	KeyType     KeyType `json:"key_type"`
-	// Bundle      string  `json:"bundle"`
-	// Modified    int     `json:"mtime"`
-	// Created     int     `json:"ctime"`
+	Bundle      string  `json:"bundle"`
+	Modified    int     `json:"mtime"`
+	Created     int     `json:"ctime"`
+	SigningKID  string  `json:"signing_kid"`
+	EldestKID   string  `json:"eldest_kid"`
+	KeyBits     int     `json:"key_bits"`
+	KeyAlgo     int     `json:"key_algo"`
+}
+
+// IsPGP reports whether the key bundle holds an armored PGP key
+func (k *Key) IsPGP() bool {
+
+	return k != nil && strings.HasPrefix(strings.TrimSpace(k.Bundle), pgpArmorHeader)
+}
+
+// pgpArmorHeader starts every armored PGP public key bundle
+const pgpArmorHeader = "-----BEGIN PGP PUBLIC KEY BLOCK-----"
+
+// PublicKeys contains the public keys of a user as returned in the "public_keys" field of the keybase API
+type PublicKeys struct {
+	// Primary is the user's primary key
+	Primary *Key `json:"primary"`
+	// AllBundles holds the armored bundles of every active key
+	AllBundles []string `json:"all_bundles"`
+	// PGPPublicKeys holds the armored bundles of every active PGP key
+	PGPPublicKeys []string `json:"pgp_public_keys"`
+	// PGPPublicKeyKIDs holds the key IDs of every active PGP key
+	PGPPublicKeyKIDs []string `json:"pgp_public_key_kids"`
+	// Sibkeys holds the key IDs of the user's sibkeys
+	Sibkeys []string `json:"sibkeys"`
+	// Subkeys holds the key IDs of the user's subkeys
+	Subkeys []string `json:"subkeys"`
+	// EldestKID is the key ID of the user's eldest key
+	EldestKID string `json:"eldest_kid"`
+}
+
+// PrimaryPGPBundle returns the armored PGP public key of the user
+// the primary key is preferred, falling back to the first active PGP key
+// the empty string is returned when the user has no PGP key
+func (pk *PublicKeys) PrimaryPGPBundle() string {
+
+	if pk == nil {
+
+		return ""
+	}
+	if pk.Primary.IsPGP() {
+
+		return pk.Primary.Bundle
+	}
+	for _, b := range pk.PGPPublicKeys {
+
+		if strings.HasPrefix(strings.TrimSpace(b), pgpArmorHeader) {
+
+			return b
+		}
+	}
+
+	return ""
 }
 
 func init() {
@@ -130,173 +271,311 @@ func init() {
 
 }
 
+// UserResult is the outcome of looking up a single requested username
+type UserResult struct {
+	// Username is the normalised username as requested
+	Username string
+	// Found is true when keybase knows about the user
+	Found bool
+	// User holds the keybase user when found
+	User *User
+	// Err is the error of the failed lookup of the user, Found is meaningless when it's set
+	Err error
+}
+
+// PubKeyResult is the outcome of looking up the public key of a single requested username
+type PubKeyResult struct {
+	// Username is the normalised username as requested
+	Username string
+	// Found is true when keybase holds a primary public key for the user
+	Found bool
+	// Key is the user's primary public key when found
+	Key *Key
+	// PublicKeys holds every public key of the user, it's set whenever the user exists
+	PublicKeys *PublicKeys
+	// Err is the error of the failed lookup of the user, Found is meaningless when it's set
+	Err error
+}
+
+// NormaliseUsernames trims, lowercases and de-duplicates the given usernames, dropping empty ones
+// the order of the first occurrence of every username is preserved
+func NormaliseUsernames(username []string) []string {
+
+	var normalised []string
+	seen := make(map[string]bool)
+
+	for _, u := range username {
+
+		u = strings.ToLower(strings.TrimSpace(u))
+		if u == "" || seen[u] {
+
+			continue
+		}
+		seen[u] = true
+		normalised = append(normalised, u)
+	}
+
+	return normalised
+}
+
 // UserLookup is used to lookup users using the keybase API
-func UserLookup(username []string) ([]string, []string, error) {
+// the results follow the order of the normalised usernames, see NormaliseUsernames
+func (c *Client) UserLookup(ctx context.Context, username []string) ([]UserResult, error) {
 
 	log.DebugLog.Printf("lookup username(s): %v", username)
-	var uf, unf []string
 
 	// step: lookup username
-	uf, unf, errl := lookupUser(username)
+	ur, errl := c.lookupUser(ctx, NormaliseUsernames(username))
 	if errl != nil {
 
 		if unfe, ok := errl.(ErrorUserNotFound); ok {
 
 			log.DebugLog.Printf("received a ErrorUserNotFound error: %v", unfe)
 		}
-		return uf, unf, errl
+		return ur, errl
 	}
 
-	return uf, unf, nil
+	return ur, nil
 }
 
-// lookupUser uses the keybase API to lookup the given user
-func lookupUser(username []string) ([]string, []string, error) {
+// fetchUsers uses the keybase API to fetch the given users with the requested fields
+// the returned users are keyed by their lowercased username, users unknown to keybase are absent
+func (c *Client) fetchUsers(ctx context.Context, username []string, fields ...string) (map[string]*User, error) {
+
+	return c.fetchCached(ctx, SelectorUsername, username, fields...)
+}
+
+// fetchChunk uses the keybase API to fetch, in a single request, the users matching the given selector values with the requested fields
+// the returned users are keyed by the selector value they correlate with, values matching no user are absent
+func (c *Client) fetchChunk(ctx context.Context, sel Selector, values []string, fields ...string) (map[string]*User, error) {
 
 	var userResponse struct {
 		Status *Status `json:"status"`
 		User   []*User `json:"them"`
 	}
-	var empty []string
-	var userFound []string
-	var userNotFound []string
 
-	uname := strings.Join(username, ",")
+	// basics are always requested, the other fields needed to correlate the entries are added per selector
+	query := url.Values{}
+	query.Set(string(sel), strings.Join(values, ","))
+	query.Set("fields", strings.Join(sel.fields(fields), ","))
 
-	url := fmt.Sprintf("%s%s&fields=basics", keybaseUserLookupURL, uname)
-	log.DebugLog.Printf("targeting keybase API url: %s", url)
-	res, errlu := http.Get(url)
+	respb, errlu := c.get(ctx, userLookupPath, query)
 	if errlu != nil {
 
-		return empty, empty, errlu
+		return nil, errlu
 	}
+	// log.DebugLog.Printf("response body: %s", respb)
 
-	respb, errRA := ioutil.ReadAll(res.Body)
-	log.DebugLog.Printf("response body: %s", respb)
-	if errRA != nil {
+	errDec := json.Unmarshal(respb, &userResponse)
+
+	if errDec != nil {
 
-		return empty, empty, errRA
+		return nil, errDec
 	}
 
-	defer res.Body.Close()
-	errDec := json.Unmarshal(respb, &userResponse)
+	if errst := checkStatus(userResponse.Status); errst != nil {
 
-	if errDec != nil {
+		return nil, errst
+	}
+
+	// step: correlate the returned users with the requested values
+	// null entries can't be correlated, their value is absent from the result
+	return sel.correlate(values, userResponse.User), nil
+}
+
+// lookupUser uses the keybase API to lookup the given user
+func (c *Client) lookupUser(ctx context.Context, username []string) ([]UserResult, error) {
+
+	byUsername, errfu := c.fetchUsers(ctx, username)
+	if errfu != nil && failedValues(errfu) == nil {
 
-		return empty, empty, errDec
+		return nil, errfu
 	}
 
-	for u := range userResponse.User {
+	return userResults(username, byUsername, errfu)
+}
+
+// userResults builds the user lookup results of the given usernames from the fetched users
+// errfu is the error of a partially failed fetch, it's returned when any of the given usernames couldn't be fetched
+func userResults(username []string, byUsername map[string]*User, errfu error) ([]UserResult, error) {
+
+	var userNotFound []string
+	var lookupFailed bool
 
-		if userResponse.User[u] != nil {
+	failed := failedValues(errfu)
+	results := make([]UserResult, 0, len(username))
+	for _, u := range username {
 
-			log.DebugLog.Printf("user %s found", username[u])
-			userFound = append(userFound, username[u])
-			// log.DebugLog.Printf("unmarshalled resp user: %v", userResponse.User[u].Basics.Username)
-			// log.DebugLog.Printf("unmarshalled resp salt: %v", userResponse.User[u].Basics.Salt)
-			// log.DebugLog.Printf("unmarshalled resp track_version: %v", userResponse.User[u].Basics.TrackVersion)
+		if errf, ok := failed[u]; ok {
 
+			log.DebugLog.Printf("lookup of user %s failed: %v", u, errf)
+			lookupFailed = true
+			results = append(results, UserResult{Username: u, Err: errf})
+		} else if ru, ok := byUsername[u]; ok {
+
+			log.DebugLog.Printf("user %s found", u)
+			results = append(results, UserResult{Username: u, Found: true, User: ru})
 		} else {
 
-			msg := fmt.Sprintf("user %s not found", username[u])
-			log.DebugLog.Printf(msg)
-			userNotFound = append(userNotFound, username[u])
+			log.DebugLog.Printf("user %s not found", u)
+			userNotFound = append(userNotFound, u)
+			results = append(results, UserResult{Username: u})
 		}
 	}
 
-	if len(userNotFound) == 0 {
+	if lookupFailed {
 
-		return userFound, empty, nil
-	} else {
+		return results, errfu
+	}
+	if len(userNotFound) == 0 {
 
-		msg := fmt.Sprintf("user(s) %v not found", userNotFound)
-		var eunf ErrorUserNotFound
-		eunf.errmsg = msg
-		return userFound, userNotFound, eunf
+		return results, nil
 	}
+
+	msg := fmt.Sprintf("user(s) %v not found", userNotFound)
+	var eunf ErrorUserNotFound
+	eunf.errmsg = msg
+	return results, eunf
 }
 
 // PubKeyLookup is used to lookup pubkeys using the keybase API
-func PubKeyLookup(username []string) ([]string, []string, error) {
+// the results follow the order of the normalised usernames, see NormaliseUsernames
+func (c *Client) PubKeyLookup(ctx context.Context, username []string) ([]PubKeyResult, error) {
 
 	log.DebugLog.Printf("lookup pubkey for username(s): %v", username)
-	var kf, knf []string
 
 	// step: lookup username's pubkey
-	kf, knf, errl := lookupPubKey(username)
+	kr, errl := c.lookupPubKey(ctx, NormaliseUsernames(username))
 	if errl != nil {
 
 		if pknfe, ok := errl.(ErrorPKNotFound); ok {
 
 			log.DebugLog.Printf("received a ErrorPKNotFound error: %v", pknfe)
 		}
-		return kf, knf, errl
+		return kr, errl
 	}
 
-	return kf, knf, nil
+	return kr, nil
 }
 
 // lookupPubKey uses the keybase API to lookup the given user's pubkey
-func lookupPubKey(username []string) ([]string, []string, error) {
+func (c *Client) lookupPubKey(ctx context.Context, username []string) ([]PubKeyResult, error) {
 
-	var pubKeyResponse struct {
-		Status *Status `json:"status"`
-		Key    []*Key  `json:"them"`
+	users, errfu := c.fetchUsers(ctx, username, "public_keys")
+	if errfu != nil && failedValues(errfu) == nil {
+
+		return nil, errfu
 	}
-	var empty, pubKeyFound, pubKeyNotFound []string
 
-	uname := strings.Join(username, ",")
+	return pubKeyResults(username, users, errfu)
+}
 
-	url := fmt.Sprintf("%s%s&fields=public_keys", keybaseUserLookupURL, uname)
-	log.DebugLog.Printf("targeting keybase API url: %s", url)
-	res, errlu := http.Get(url)
-	if errlu != nil {
+// pubKeyResults builds the public key lookup results of the given usernames from the fetched users
+// errfu is the error of a partially failed fetch, it's returned when any of the given usernames couldn't be fetched
+func pubKeyResults(username []string, users map[string]*User, errfu error) ([]PubKeyResult, error) {
+
+	var pubKeyNotFound []string
+	var lookupFailed bool
 
-		return empty, empty, errlu
+	byUsername := make(map[string]*PublicKeys)
+	for u, ru := range users {
+
+		byUsername[u] = ru.PublicKeys
 	}
 
-	respb, errRA := ioutil.ReadAll(res.Body)
-	// log.DebugLog.Printf("response body: %s", respb)
-	if errRA != nil {
+	failed := failedValues(errfu)
+	results := make([]PubKeyResult, 0, len(username))
+	for _, u := range username {
+
+		if errf, ok := failed[u]; ok {
+
+			log.DebugLog.Printf("public key lookup of user %s failed: %v", u, errf)
+			lookupFailed = true
+			results = append(results, PubKeyResult{Username: u, Err: errf})
+			continue
+		}
+
+		pk := byUsername[u]
+		if pk != nil && pk.Primary != nil {
+
+			log.DebugLog.Printf("public key for user %s found, kid: %s fingerprint: %s", u, pk.Primary.KeyID, pk.Primary.Fingerprint)
+			results = append(results, PubKeyResult{Username: u, Found: true, Key: pk.Primary, PublicKeys: pk})
+		} else {
 
-		return empty, empty, errRA
+			log.DebugLog.Printf("public key for user %s not found", u)
+			pubKeyNotFound = append(pubKeyNotFound, u)
+			results = append(results, PubKeyResult{Username: u, PublicKeys: pk})
+		}
 	}
 
-	defer res.Body.Close()
-	errDec := json.Unmarshal(respb, &pubKeyResponse)
+	if lookupFailed {
 
-	if errDec != nil {
+		return results, errfu
+	}
+	if len(pubKeyNotFound) == 0 {
 
-		return empty, empty, errDec
+		return results, nil
 	}
 
-	for u := range pubKeyResponse.Key {
+	msg := fmt.Sprintf("public key for user(s) %v not found", pubKeyNotFound)
+	var eunf ErrorPKNotFound
+	eunf.errmsg = msg
+	return results, eunf
+}
 
-		if pubKeyResponse.Key[u] != nil {
+// NormaliseFingerprint lowercases a key fingerprint and strips any 0x prefix and whitespace from it
+// so that fingerprints copied from gpg output compare equal to the ones returned by keybase
+func NormaliseFingerprint(fingerprint string) string {
 
-			log.DebugLog.Printf("public key for user %s found", username[u])
-			pubKeyFound = append(pubKeyFound, username[u])
-			// log.DebugLog.Printf("unmarshalled resp user: %v", pubKeyResponse.Key[u].Basics.Username)
-			// log.DebugLog.Printf("unmarshalled resp salt: %v", pubKeyResponse.Key[u].Basics.Salt)
-			// log.DebugLog.Printf("unmarshalled resp track_version: %v", pubKeyResponse.Key[u].Basics.TrackVersion)
+	fpr := strings.ToLower(strings.Join(strings.Fields(fingerprint), ""))
+	return strings.TrimPrefix(fpr, "0x")
+}
 
-		} else {
+// VerifyFingerprints checks the fingerprint of every looked up key against the pinned ones
+// pins maps usernames to their expected fingerprint, users without a pin aren't checked
+// an ErrorFingerprintMismatch listing every offending user is returned when any pin doesn't match
+func VerifyFingerprints(results []PubKeyResult, pins map[string]string) error {
 
-			msg := fmt.Sprintf("public key for user %s not found", username)
-			log.DebugLog.Printf(msg)
-			pubKeyNotFound = append(pubKeyNotFound, username[u])
-		}
+	var mismatches []FingerprintMismatch
+	var offenders []string
+
+	normalisedPins := make(map[string]string)
+	for u, fpr := range pins {
+
+		normalisedPins[strings.ToLower(strings.TrimSpace(u))] = NormaliseFingerprint(fpr)
 	}
 
-	if len(pubKeyNotFound) == 0 {
+	for _, r := range results {
 
-		return pubKeyFound, empty, nil
-	} else {
+		expected, ok := normalisedPins[r.Username]
+		if !ok {
+
+			continue
+		}
+
+		var actual string
+		if r.Key != nil {
+
+			actual = NormaliseFingerprint(r.Key.Fingerprint)
+		}
+		if actual == expected {
+
+			log.DebugLog.Printf("fingerprint of user %s matches the pinned one: %s", r.Username, expected)
+			continue
+		}
+
+		log.DebugLog.Printf("fingerprint of user %s doesn't match the pinned one, expected: %s actual: %s", r.Username, expected, actual)
+		mismatches = append(mismatches, FingerprintMismatch{Username: r.Username, Expected: expected, Actual: actual})
+		offenders = append(offenders, r.Username)
+	}
+
+	if len(mismatches) == 0 {
 
-		msg := fmt.Sprintf("public key for user(s) %v not found", pubKeyNotFound)
-		var eunf ErrorPKNotFound
-		eunf.errmsg = msg
-		return pubKeyFound, pubKeyNotFound, eunf
+		return nil
 	}
 
+	var efm ErrorFingerprintMismatch
+	efm.errmsg = fmt.Sprintf("public key fingerprint for user(s) %v doesn't match the pinned fingerprint", offenders)
+	efm.Mismatches = mismatches
+	return efm
 }
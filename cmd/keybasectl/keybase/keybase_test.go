@@ -0,0 +1,166 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keybase
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCheckStatus(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		status *Status
+		want   error
+	}{
+		{name: "success", status: &Status{Code: statusOK, Name: "OK"}},
+		{name: "failure", status: &Status{Code: 100, Name: "INPUT_ERROR", Desc: "bad list value"}, want: ErrorAPIStatus{Code: 100, Name: "INPUT_ERROR", Desc: "bad list value"}},
+	}
+
+	for _, tt := range tests {
+
+		if got := checkStatus(tt.status); got != tt.want {
+
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+
+	if errst := checkStatus(nil); errst == nil {
+
+		t.Errorf("expected a response without a status block to fail")
+	}
+}
+
+func TestHTTPStatusError(t *testing.T) {
+
+	tests := []struct {
+		name string
+		code int
+		body string
+		want error
+	}{
+		{
+			name: "status block",
+			code: 400,
+			body: `{"status": {"code": 100, "name": "INPUT_ERROR", "desc": "bad list value"}}`,
+			want: ErrorAPIStatus{Code: 100, Name: "INPUT_ERROR", Desc: "bad list value", HTTPStatus: 400},
+		},
+		{
+			name: "successful status block",
+			code: 502,
+			body: `{"status": {"code": 0, "name": "OK"}}`,
+			want: ErrorHTTPStatus{StatusCode: 502, Status: "502 Bad Gateway"},
+		},
+		{
+			name: "no status block",
+			code: 502,
+			body: `<html>bad gateway</html>`,
+			want: ErrorHTTPStatus{StatusCode: 502, Status: "502 Bad Gateway"},
+		},
+	}
+
+	for _, tt := range tests {
+
+		res := &http.Response{StatusCode: tt.code, Status: fmt.Sprintf("%d %s", tt.code, http.StatusText(tt.code)), Header: make(http.Header)}
+		if got := httpStatusError(res, []byte(tt.body)); !reflect.DeepEqual(got, tt.want) {
+
+			t.Errorf("%s: expected %#v, got %#v", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestNormaliseFingerprint(t *testing.T) {
+
+	tests := map[string]string{
+		"52A458322E924A5106F2562AC17B21BA395A8D3C":           "52a458322e924a5106f2562ac17b21ba395a8d3c",
+		"0x52A458322E924A5106F2562AC17B21BA395A8D3C":         "52a458322e924a5106f2562ac17b21ba395a8d3c",
+		"52A4 5832 2E92 4A51 06F2  562A C17B 21BA 395A 8D3C": "52a458322e924a5106f2562ac17b21ba395a8d3c",
+		" 52a458322e924a5106f2562ac17b21ba395a8d3c\n":        "52a458322e924a5106f2562ac17b21ba395a8d3c",
+	}
+
+	for fpr, want := range tests {
+
+		if got := NormaliseFingerprint(fpr); got != want {
+
+			t.Errorf("NormaliseFingerprint(%q): expected %q, got %q", fpr, want, got)
+		}
+	}
+}
+
+func TestVerifyFingerprints(t *testing.T) {
+
+	const aliceFpr = "52a458322e924a5106f2562ac17b21ba395a8d3c"
+
+	results := []PubKeyResult{
+		{Username: "alice", Found: true, Key: &Key{Fingerprint: strings.ToUpper(aliceFpr)}},
+		{Username: "bob"},
+		{Username: "carol", Found: true, Key: &Key{Fingerprint: "9f3a4b2c1d0e9f8a7b6c5d4e3f2a1b0c9d8e7f6a"}},
+	}
+
+	tests := []struct {
+		name string
+		pins map[string]string
+		want []FingerprintMismatch
+	}{
+		{
+			name: "no pins",
+		},
+		{
+			name: "pin matches whatever its formatting",
+			pins: map[string]string{" Alice ": "0x52A4 5832 2E92 4A51 06F2 562A C17B 21BA 395A 8D3C"},
+		},
+		{
+			name: "pins of users not looked up are ignored",
+			pins: map[string]string{"dave": aliceFpr},
+		},
+		{
+			name: "fingerprint mismatch",
+			pins: map[string]string{"alice": aliceFpr, "carol": aliceFpr},
+			want: []FingerprintMismatch{{Username: "carol", Expected: aliceFpr, Actual: "9f3a4b2c1d0e9f8a7b6c5d4e3f2a1b0c9d8e7f6a"}},
+		},
+		{
+			name: "pinned user without a key",
+			pins: map[string]string{"bob": aliceFpr},
+			want: []FingerprintMismatch{{Username: "bob", Expected: aliceFpr}},
+		},
+	}
+
+	for _, tt := range tests {
+
+		errvf := VerifyFingerprints(results, tt.pins)
+		if len(tt.want) == 0 {
+
+			if errvf != nil {
+
+				t.Errorf("%s: expected no error, got %v", tt.name, errvf)
+			}
+			continue
+		}
+
+		efm, ok := errvf.(ErrorFingerprintMismatch)
+		if !ok {
+
+			t.Errorf("%s: expected an ErrorFingerprintMismatch, got %T: %v", tt.name, errvf, errvf)
+			continue
+		}
+		if !reflect.DeepEqual(efm.Mismatches, tt.want) {
+
+			t.Errorf("%s: expected the mismatches %+v, got %+v", tt.name, tt.want, efm.Mismatches)
+		}
+	}
+}
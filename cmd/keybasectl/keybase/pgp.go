@@ -0,0 +1,284 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keybase
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/pkg/errors"
+	log "github.com/stefancocora/keybasectl/internal/log"
+)
+
+// DefaultMinRSABits is the minimum RSA, DSA and ElGamal key size accepted by DefaultKeyPolicy
+const DefaultMinRSABits = 2048
+
+// DefaultKeyPolicy is the key policy applied when none is given
+var DefaultKeyPolicy = KeyPolicy{MinRSABits: DefaultMinRSABits}
+
+// ErrorKeyInvalid is the error returned when a user's PGP public key fails local validation
+type ErrorKeyInvalid struct {
+	err         error
+	errmsg      string
+	Validations []KeyValidation
+}
+
+// Error implements the error interface for a type of ErrorKeyInvalid
+func (ki ErrorKeyInvalid) Error() string {
+	return ki.errmsg
+}
+
+// KeyPolicy holds the requirements a PGP public key has to satisfy
+type KeyPolicy struct {
+	// MinRSABits is the minimum key size of RSA, DSA and ElGamal keys, 0 disables the check
+	MinRSABits int
+	// ExpiryWindow makes keys expiring within that window fail, 0 only fails keys already expired
+	ExpiryWindow time.Duration
+}
+
+// KeyInfo describes a PGP public key as parsed locally from its armored bundle
+type KeyInfo struct {
+	// Fingerprint is the fingerprint computed from the key material
+	Fingerprint string
+	// Algorithm is the public key algorithm of the primary key, e.g. RSA, ECDSA
+	Algorithm string
+	// BitLength is the size of the primary key in bits
+	BitLength int
+	// Created is the creation time of the primary key
+	Created time.Time
+	// Expires is the expiry time of the primary key, zero when it never expires
+	Expires time.Time
+	// Revoked is true when the primary key carries a revocation signature
+	Revoked bool
+	// Encrypts is true when the key holds a valid encryption key, an unexpired and unrevoked subkey or the primary key
+	Encrypts bool
+	// EncryptionExpires is the expiry time of the encryption subkey, zero when it never expires or the primary key encrypts
+	EncryptionExpires time.Time
+	// Identities holds the user IDs bound to the key
+	Identities []string
+}
+
+// KeyValidation is the outcome of validating the PGP public key of a single user
+type KeyValidation struct {
+	// Username is the normalised username
+	Username string
+	// Info describes the parsed key, nil when the key couldn't be parsed
+	Info *KeyInfo
+	// Problems lists every policy the key violates, empty when the key is valid
+	Problems []string
+}
+
+// algorithmNames maps the openpgp public key algorithms to friendly names
+var algorithmNames = map[packet.PublicKeyAlgorithm]string{
+	packet.PubKeyAlgoRSA:            "RSA",
+	packet.PubKeyAlgoRSAEncryptOnly: "RSA",
+	packet.PubKeyAlgoRSASignOnly:    "RSA",
+	packet.PubKeyAlgoElGamal:        "ElGamal",
+	packet.PubKeyAlgoDSA:            "DSA",
+	packet.PubKeyAlgoECDH:           "ECDH",
+	packet.PubKeyAlgoECDSA:          "ECDSA",
+	packet.PubKeyAlgoEdDSA:          "EdDSA",
+	packet.PubKeyAlgoX25519:         "X25519",
+	packet.PubKeyAlgoX448:           "X448",
+	packet.PubKeyAlgoEd25519:        "Ed25519",
+	packet.PubKeyAlgoEd448:          "Ed448",
+}
+
+// curveBits maps the openpgp elliptic curves to their size in bits
+var curveBits = map[packet.Curve]int{
+	packet.Curve25519:         255,
+	packet.Curve448:           448,
+	packet.CurveNistP256:      256,
+	packet.CurveNistP384:      384,
+	packet.CurveNistP521:      521,
+	packet.CurveSecP256k1:     256,
+	packet.CurveBrainpoolP256: 256,
+	packet.CurveBrainpoolP384: 384,
+	packet.CurveBrainpoolP512: 512,
+}
+
+// ParsePGPBundle parses an armored PGP public key bundle and describes its primary key
+func ParsePGPBundle(bundle string) (*KeyInfo, error) {
+
+	el, errrk := openpgp.ReadArmoredKeyRing(strings.NewReader(bundle))
+	if errrk != nil {
+
+		return nil, errors.Wrap(errrk, "unable to parse the PGP public key bundle")
+	}
+	if len(el) == 0 {
+
+		return nil, errors.New("the PGP public key bundle holds no key")
+	}
+
+	e := el[0]
+	pk := e.PrimaryKey
+	ki := &KeyInfo{
+		Fingerprint: hex.EncodeToString(pk.Fingerprint),
+		Algorithm:   algorithmNames[pk.PubKeyAlgo],
+		Created:     pk.CreationTime,
+		Revoked:     len(e.Revocations) > 0,
+	}
+	if ki.Algorithm == "" {
+
+		ki.Algorithm = fmt.Sprintf("unknown(%d)", pk.PubKeyAlgo)
+	}
+
+	// the size of an elliptic curve key is the one of its curve, not the one of the encoded point
+	if curve, errc := pk.Curve(); errc == nil && curveBits[curve] > 0 {
+
+		ki.BitLength = curveBits[curve]
+	} else if bl, errbl := pk.BitLength(); errbl == nil {
+
+		ki.BitLength = int(bl)
+	}
+
+	// the key lifetime lives in the self signatures, the latest one wins
+	var latest *packet.Signature
+	for name, id := range e.Identities {
+
+		ki.Identities = append(ki.Identities, name)
+		if id.SelfSignature != nil && (latest == nil || id.SelfSignature.CreationTime.After(latest.CreationTime)) {
+
+			latest = id.SelfSignature
+		}
+	}
+	sort.Strings(ki.Identities)
+	if latest != nil && latest.KeyLifetimeSecs != nil && *latest.KeyLifetimeSecs != 0 {
+
+		ki.Expires = pk.CreationTime.Add(time.Duration(*latest.KeyLifetimeSecs) * time.Second)
+	}
+
+	// the encryption key is the newest valid subkey flagged for encryption, the primary key when there's none
+	if ek, ok := e.EncryptionKey(time.Now()); ok {
+
+		ki.Encrypts = true
+		sig := ek.SelfSignature
+		if ek.PublicKey != pk && sig.KeyLifetimeSecs != nil && *sig.KeyLifetimeSecs != 0 {
+
+			ki.EncryptionExpires = ek.PublicKey.CreationTime.Add(time.Duration(*sig.KeyLifetimeSecs) * time.Second)
+		}
+	}
+
+	return ki, nil
+}
+
+// ValidatePGPKey parses the bundle of the given key and checks it against its advertised fingerprint, when it has one, and the policy
+// the returned problems are empty when the key is valid
+func ValidatePGPKey(k *Key, policy KeyPolicy) (*KeyInfo, []string) {
+
+	var problems []string
+
+	if !k.IsPGP() {
+
+		return nil, []string{"no PGP public key bundle"}
+	}
+
+	ki, errp := ParsePGPBundle(k.Bundle)
+	if errp != nil {
+
+		return nil, []string{errp.Error()}
+	}
+
+	if k.Fingerprint != "" && ki.Fingerprint != NormaliseFingerprint(k.Fingerprint) {
+
+		problems = append(problems, fmt.Sprintf("computed fingerprint %s doesn't match the advertised fingerprint %s", ki.Fingerprint, NormaliseFingerprint(k.Fingerprint)))
+	}
+	if ki.Revoked {
+
+		problems = append(problems, "key is revoked")
+	}
+
+	now := time.Now()
+	expired := !ki.Expires.IsZero() && ki.Expires.Before(now)
+	if expired {
+
+		problems = append(problems, fmt.Sprintf("key expired on %s", ki.Expires.UTC().Format(time.RFC3339)))
+	} else if !ki.Expires.IsZero() && policy.ExpiryWindow > 0 && ki.Expires.Before(now.Add(policy.ExpiryWindow)) {
+
+		problems = append(problems, fmt.Sprintf("key expires on %s, within %s", ki.Expires.UTC().Format(time.RFC3339), policy.ExpiryWindow))
+	}
+
+	// an expired or revoked primary key has no valid encryption key either, it's reported once
+	if !ki.Encrypts && !expired && !ki.Revoked {
+
+		problems = append(problems, "no valid encryption key, every encryption subkey is expired or revoked")
+	} else if !ki.EncryptionExpires.IsZero() && policy.ExpiryWindow > 0 && ki.EncryptionExpires.Before(now.Add(policy.ExpiryWindow)) {
+
+		problems = append(problems, fmt.Sprintf("encryption subkey expires on %s, within %s", ki.EncryptionExpires.UTC().Format(time.RFC3339), policy.ExpiryWindow))
+	}
+
+	switch ki.Algorithm {
+	case "RSA", "DSA", "ElGamal":
+		if policy.MinRSABits > 0 && ki.BitLength < policy.MinRSABits {
+
+			problems = append(problems, fmt.Sprintf("weak %s key of %d bits, the minimum is %d bits", ki.Algorithm, ki.BitLength, policy.MinRSABits))
+		}
+	}
+
+	return ki, problems
+}
+
+// ValidatePubKeys validates the PGP public key of every user found, the one PublicKeys.PrimaryPGPBundle returns:
+// the primary key when it's a PGP key, else the first active PGP key, which has no advertised fingerprint to check
+// an ErrorKeyInvalid listing every offending user is returned when any key fails validation
+func ValidatePubKeys(results []PubKeyResult, policy KeyPolicy) ([]KeyValidation, error) {
+
+	var validations []KeyValidation
+	var offenders []string
+
+	for _, r := range results {
+
+		if !r.Found {
+
+			continue
+		}
+
+		ki, problems := ValidatePGPKey(pgpKey(r), policy)
+		if ki != nil {
+
+			log.DebugLog.Printf("public key of user %s: fingerprint=%s algorithm=%s bits=%d expires=%v revoked=%v", r.Username, ki.Fingerprint, ki.Algorithm, ki.BitLength, ki.Expires, ki.Revoked)
+		}
+		if len(problems) > 0 {
+
+			log.DebugLog.Printf("public key of user %s failed validation: %v", r.Username, problems)
+			offenders = append(offenders, r.Username)
+		}
+		validations = append(validations, KeyValidation{Username: r.Username, Info: ki, Problems: problems})
+	}
+
+	if len(offenders) == 0 {
+
+		return validations, nil
+	}
+
+	var eki ErrorKeyInvalid
+	eki.errmsg = fmt.Sprintf("public key for user(s) %v failed validation", offenders)
+	eki.Validations = validations
+	return validations, eki
+}
+
+// pgpKey returns the PGP key of the looked up user, the primary key unless it isn't a PGP key
+func pgpKey(r PubKeyResult) *Key {
+
+	if r.Key.IsPGP() || r.PublicKeys == nil {
+
+		return r.Key
+	}
+	return &Key{Bundle: r.PublicKeys.PrimaryPGPBundle()}
+}
@@ -0,0 +1,321 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keybase
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+)
+
+// testKeySpec describes the PGP key generated by testPGPKey
+type testKeySpec struct {
+	// rsaBits generates an RSA key of that size instead of an ed25519 one
+	rsaBits int
+	// created is the creation time of the key, zero means now
+	created time.Time
+	// lifetime is the validity period of the key, zero means the key never expires
+	lifetime time.Duration
+	// revoked adds a revocation signature to the key
+	revoked bool
+	// subkeyLifetime replaces the encryption subkey with one of that validity period
+	subkeyLifetime time.Duration
+	// noSubkey drops the encryption subkey
+	noSubkey bool
+}
+
+// testPGPKey generates a PGP key for alice@example.com and returns it the way keybase serves it
+func testPGPKey(t *testing.T, spec testKeySpec) *Key {
+
+	t.Helper()
+
+	cfg := &packet.Config{Algorithm: packet.PubKeyAlgoEdDSA, KeyLifetimeSecs: uint32(spec.lifetime / time.Second)}
+	if spec.rsaBits > 0 {
+
+		cfg.Algorithm = packet.PubKeyAlgoRSA
+		cfg.RSABits = spec.rsaBits
+	}
+	if !spec.created.IsZero() {
+
+		cfg.Time = func() time.Time { return spec.created }
+	}
+
+	e, errne := openpgp.NewEntity("alice", "", "alice@example.com", cfg)
+	if errne != nil {
+
+		t.Fatalf("unable to generate a PGP key: %v", errne)
+	}
+	if spec.revoked {
+
+		if errrk := e.RevokeKey(packet.KeyCompromised, "", cfg); errrk != nil {
+
+			t.Fatalf("unable to revoke the PGP key: %v", errrk)
+		}
+	}
+	if spec.noSubkey || spec.subkeyLifetime > 0 {
+
+		e.Subkeys = nil
+	}
+	if spec.subkeyLifetime > 0 {
+
+		scfg := *cfg
+		scfg.KeyLifetimeSecs = uint32(spec.subkeyLifetime / time.Second)
+		if errsk := e.AddEncryptionSubkey(&scfg); errsk != nil {
+
+			t.Fatalf("unable to add an encryption subkey: %v", errsk)
+		}
+	}
+
+	var buf bytes.Buffer
+	w, erra := armor.Encode(&buf, openpgp.PublicKeyType, nil)
+	if erra != nil {
+
+		t.Fatalf("unable to armor the PGP key: %v", erra)
+	}
+	if errs := e.Serialize(w); errs != nil {
+
+		t.Fatalf("unable to serialize the PGP key: %v", errs)
+	}
+	if errc := w.Close(); errc != nil {
+
+		t.Fatalf("unable to armor the PGP key: %v", errc)
+	}
+
+	return &Key{Fingerprint: strings.ToUpper(hex.EncodeToString(e.PrimaryKey.Fingerprint)), Bundle: buf.String()}
+}
+
+func TestParsePGPBundle(t *testing.T) {
+
+	created := time.Now().Add(-time.Hour).Truncate(time.Second)
+
+	tests := []struct {
+		name       string
+		spec       testKeySpec
+		algorithm  string
+		bits       int
+		expires    time.Time
+		revoked    bool
+		encExpires time.Time
+	}{
+		{name: "ed25519 key", spec: testKeySpec{created: created}, algorithm: "EdDSA", bits: 255},
+		{name: "rsa key", spec: testKeySpec{rsaBits: 1024, created: created}, algorithm: "RSA", bits: 1024},
+		{name: "expiring key", spec: testKeySpec{created: created, lifetime: 24 * time.Hour}, algorithm: "EdDSA", bits: 255, expires: created.Add(24 * time.Hour)},
+		{name: "revoked key", spec: testKeySpec{created: created, revoked: true}, algorithm: "EdDSA", bits: 255, revoked: true},
+		{name: "expiring encryption subkey", spec: testKeySpec{created: created, subkeyLifetime: 24 * time.Hour}, algorithm: "EdDSA", bits: 255, encExpires: created.Add(24 * time.Hour)},
+	}
+
+	for _, tt := range tests {
+
+		k := testPGPKey(t, tt.spec)
+		ki, errp := ParsePGPBundle(k.Bundle)
+		if errp != nil {
+
+			t.Errorf("%s: unexpected error: %v", tt.name, errp)
+			continue
+		}
+		if ki.Fingerprint != NormaliseFingerprint(k.Fingerprint) {
+
+			t.Errorf("%s: expected fingerprint %s, got %s", tt.name, NormaliseFingerprint(k.Fingerprint), ki.Fingerprint)
+		}
+		if ki.Algorithm != tt.algorithm || ki.BitLength != tt.bits {
+
+			t.Errorf("%s: expected a %d bits %s key, got a %d bits %s key", tt.name, tt.bits, tt.algorithm, ki.BitLength, ki.Algorithm)
+		}
+		if !ki.Created.Equal(created) {
+
+			t.Errorf("%s: expected creation time %s, got %s", tt.name, created, ki.Created)
+		}
+		if !ki.Expires.Equal(tt.expires) {
+
+			t.Errorf("%s: expected expiry time %s, got %s", tt.name, tt.expires, ki.Expires)
+		}
+		if !ki.EncryptionExpires.Equal(tt.encExpires) {
+
+			t.Errorf("%s: expected encryption subkey expiry time %s, got %s", tt.name, tt.encExpires, ki.EncryptionExpires)
+		}
+		if ki.Revoked != tt.revoked {
+
+			t.Errorf("%s: expected revoked %v, got %v", tt.name, tt.revoked, ki.Revoked)
+		}
+		if len(ki.Identities) != 1 || ki.Identities[0] != "alice <alice@example.com>" {
+
+			t.Errorf("%s: expected the identity alice <alice@example.com>, got %v", tt.name, ki.Identities)
+		}
+	}
+
+	for _, bundle := range []string{"", pgpArmorHeader + "\n\nnot a key\n-----END PGP PUBLIC KEY BLOCK-----\n"} {
+
+		if _, errp := ParsePGPBundle(bundle); errp == nil {
+
+			t.Errorf("expected the bundle %q to be rejected", bundle)
+		}
+	}
+}
+
+func TestValidatePGPKey(t *testing.T) {
+
+	valid := testPGPKey(t, testKeySpec{})
+
+	tests := []struct {
+		name    string
+		key     *Key
+		policy  KeyPolicy
+		problem string // a substring of the single expected problem, empty when the key is valid
+	}{
+		{
+			name:   "valid key",
+			key:    valid,
+			policy: DefaultKeyPolicy,
+		},
+		{
+			name:    "fingerprint mismatch",
+			key:     &Key{Fingerprint: "52A458322E924A5106F2562AC17B21BA395A8D3C", Bundle: valid.Bundle},
+			policy:  DefaultKeyPolicy,
+			problem: "doesn't match the advertised fingerprint 52a458322e924a5106f2562ac17b21ba395a8d3c",
+		},
+		{
+			name:    "expired key",
+			key:     testPGPKey(t, testKeySpec{created: time.Now().Add(-48 * time.Hour), lifetime: 24 * time.Hour}),
+			policy:  DefaultKeyPolicy,
+			problem: "key expired on",
+		},
+		{
+			name:    "key expiring within the window",
+			key:     testPGPKey(t, testKeySpec{lifetime: 10 * 24 * time.Hour}),
+			policy:  KeyPolicy{MinRSABits: DefaultMinRSABits, ExpiryWindow: 30 * 24 * time.Hour},
+			problem: "within 720h0m0s",
+		},
+		{
+			name:   "key expiring after the window",
+			key:    testPGPKey(t, testKeySpec{lifetime: 60 * 24 * time.Hour}),
+			policy: KeyPolicy{MinRSABits: DefaultMinRSABits, ExpiryWindow: 30 * 24 * time.Hour},
+		},
+		{
+			name:    "encryption subkey expiring within the window",
+			key:     testPGPKey(t, testKeySpec{subkeyLifetime: 10 * 24 * time.Hour}),
+			policy:  KeyPolicy{MinRSABits: DefaultMinRSABits, ExpiryWindow: 30 * 24 * time.Hour},
+			problem: "encryption subkey expires on",
+		},
+		{
+			name:   "encryption subkey expiring after the window",
+			key:    testPGPKey(t, testKeySpec{subkeyLifetime: 60 * 24 * time.Hour}),
+			policy: KeyPolicy{MinRSABits: DefaultMinRSABits, ExpiryWindow: 30 * 24 * time.Hour},
+		},
+		{
+			name:    "expired encryption subkey",
+			key:     testPGPKey(t, testKeySpec{created: time.Now().Add(-48 * time.Hour), subkeyLifetime: 24 * time.Hour}),
+			policy:  DefaultKeyPolicy,
+			problem: "no valid encryption key",
+		},
+		{
+			name:    "no encryption subkey",
+			key:     testPGPKey(t, testKeySpec{noSubkey: true}),
+			policy:  DefaultKeyPolicy,
+			problem: "no valid encryption key",
+		},
+		{
+			name:    "revoked key",
+			key:     testPGPKey(t, testKeySpec{revoked: true}),
+			policy:  DefaultKeyPolicy,
+			problem: "key is revoked",
+		},
+		{
+			name:    "rsa key below the minimum size",
+			key:     testPGPKey(t, testKeySpec{rsaBits: 1024}),
+			policy:  DefaultKeyPolicy,
+			problem: "weak RSA key of 1024 bits, the minimum is 2048 bits",
+		},
+		{
+			name:   "rsa key size check disabled",
+			key:    testPGPKey(t, testKeySpec{rsaBits: 1024}),
+			policy: KeyPolicy{},
+		},
+		{
+			name:    "no PGP bundle",
+			key:     &Key{Fingerprint: valid.Fingerprint, Bundle: "0120abcdef"},
+			policy:  DefaultKeyPolicy,
+			problem: "no PGP public key bundle",
+		},
+	}
+
+	for _, tt := range tests {
+
+		_, problems := ValidatePGPKey(tt.key, tt.policy)
+		if tt.problem == "" {
+
+			if len(problems) != 0 {
+
+				t.Errorf("%s: expected no problem, got %v", tt.name, problems)
+			}
+			continue
+		}
+		if len(problems) != 1 || !strings.Contains(problems[0], tt.problem) {
+
+			t.Errorf("%s: expected the problem %q, got %v", tt.name, tt.problem, problems)
+		}
+	}
+}
+
+func TestValidatePubKeys(t *testing.T) {
+
+	valid := testPGPKey(t, testKeySpec{})
+	revoked := testPGPKey(t, testKeySpec{revoked: true})
+
+	nacl := &Key{KeyID: "0120"}
+	results := []PubKeyResult{
+		{Username: "alice", Found: true, Key: valid},
+		{Username: "bob"},
+		{Username: "carol", Found: true, Key: nacl, PublicKeys: &PublicKeys{Primary: nacl, PGPPublicKeys: []string{revoked.Bundle}}},
+	}
+
+	validations, errv := ValidatePubKeys(results, DefaultKeyPolicy)
+	eki, ok := errv.(ErrorKeyInvalid)
+	if !ok {
+
+		t.Fatalf("expected an ErrorKeyInvalid, got %T: %v", errv, errv)
+	}
+	if len(validations) != 2 || len(eki.Validations) != 2 {
+
+		t.Fatalf("expected a validation per found key, got %d", len(validations))
+	}
+	if validations[0].Username != "alice" || len(validations[0].Problems) != 0 {
+
+		t.Errorf("expected the key of alice to be valid, got %+v", validations[0])
+	}
+	if validations[1].Username != "carol" || len(validations[1].Problems) != 1 {
+
+		t.Errorf("expected the active PGP key of carol to be revoked, got %+v", validations[1])
+	}
+	if !strings.Contains(errv.Error(), "[carol]") {
+
+		t.Errorf("expected the error to name carol only, got %v", errv)
+	}
+
+	if _, errv := ValidatePubKeys(results[:2], DefaultKeyPolicy); errv != nil {
+
+		t.Errorf("expected no error without an offending key, got %v", errv)
+	}
+
+	results[2].PublicKeys.PGPPublicKeys = nil
+	if validations, _ := ValidatePubKeys(results[2:], DefaultKeyPolicy); len(validations) != 1 || len(validations[0].Problems) != 1 || validations[0].Problems[0] != "no PGP public key bundle" {
+
+		t.Errorf("expected a user without any PGP key to fail validation, got %+v", validations)
+	}
+}
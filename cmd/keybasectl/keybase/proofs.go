@@ -0,0 +1,350 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keybase
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	log "github.com/stefancocora/keybasectl/internal/log"
+)
+
+// ErrorProofViolation is the error returned when a user's identity proofs don't satisfy the requirements
+type ErrorProofViolation struct {
+	err        error
+	errmsg     string
+	Violations []ProofViolation
+}
+
+// Error implements the error interface for a type of ErrorProofViolation
+func (pv ErrorProofViolation) Error() string {
+	return pv.errmsg
+}
+
+// ProofViolation describes a single unsatisfied identity proof requirement
+type ProofViolation struct {
+	// Username is the normalised username
+	Username string
+	// Service is the proof service, e.g. github
+	Service string
+	// Problem describes why the requirement isn't satisfied
+	Problem string
+}
+
+// A ProofState is the verification state of an identity proof as reported by keybase
+type ProofState int
+
+// These constants provide friendly names for the proof states returned by the API.
+const (
+	ProofStateNone           ProofState = 0
+	ProofStateOK             ProofState = 1
+	ProofStateTempFailure    ProofState = 2
+	ProofStatePermFailure    ProofState = 3
+	ProofStateLooking        ProofState = 4
+	ProofStateSuperseded     ProofState = 5
+	ProofStatePosted         ProofState = 6
+	ProofStateRevoked        ProofState = 7
+	ProofStateDeleted        ProofState = 8
+	ProofStateUnknownType    ProofState = 9
+	ProofStateSigHintMissing ProofState = 10
+	ProofStateUnchecked      ProofState = 11
+)
+
+var proofStateNames = map[ProofState]string{
+	ProofStateNone:           "none",
+	ProofStateOK:             "ok",
+	ProofStateTempFailure:    "temp_failure",
+	ProofStatePermFailure:    "perm_failure",
+	ProofStateLooking:        "looking",
+	ProofStateSuperseded:     "superseded",
+	ProofStatePosted:         "posted",
+	ProofStateRevoked:        "revoked",
+	ProofStateDeleted:        "deleted",
+	ProofStateUnknownType:    "unknown_type",
+	ProofStateSigHintMissing: "sig_hint_missing",
+	ProofStateUnchecked:      "unchecked",
+}
+
+// String implements the fmt.Stringer interface for a type of ProofState
+func (ps ProofState) String() string {
+
+	if n, ok := proofStateNames[ps]; ok {
+
+		return n
+	}
+	return fmt.Sprintf("unknown(%d)", int(ps))
+}
+
+// Proof contains information about a single identity proof of a user
+type Proof struct {
+	ProofType         string     `json:"proof_type"`
+	Nametag           string     `json:"nametag"`
+	State             ProofState `json:"state"`
+	ServiceURL        string     `json:"service_url"`
+	ProofURL          string     `json:"proof_url"`
+	HumanURL          string     `json:"human_url"`
+	SigID             string     `json:"sig_id"`
+	ProofID           string     `json:"proof_id"`
+	PresentationGroup string     `json:"presentation_group"`
+	PresentationTag   string     `json:"presentation_tag"`
+}
+
+// Service returns the friendly service name of the proof: github, twitter, reddit, hackernews, web or dns
+func (p *Proof) Service() string {
+
+	return NormaliseProofService(p.ProofType)
+}
+
+// Live reports whether keybase last verified the proof successfully
+func (p *Proof) Live() bool {
+
+	return p.State == ProofStateOK
+}
+
+// ProofsSummary contains the identity proofs of a user as returned in the "proofs_summary" field of the keybase API
+type ProofsSummary struct {
+	All    []*Proof `json:"all"`
+	HasWeb bool     `json:"has_web"`
+}
+
+// ProofsResult is the outcome of looking up the identity proofs of a single requested username
+type ProofsResult struct {
+	// Username is the normalised username as requested
+	Username string
+	// Found is true when keybase knows about the user
+	Found bool
+	// Proofs holds every identity proof of the user
+	Proofs []*Proof
+	// Err is the error of the failed lookup of the user, Found is meaningless when it's set
+	Err error
+}
+
+// ProofExpectation requires a user to hold a live proof for a service with a given handle
+// an empty handle is met by a live proof for the service whatever its handle
+type ProofExpectation struct {
+	Username string
+	Service  string
+	Handle   string
+}
+
+// proofServiceAliases maps the keybase proof types and common aliases to the friendly service names
+var proofServiceAliases = map[string]string{
+	"generic_web_site": "web",
+	"website":          "web",
+	"https":            "web",
+	"http":             "web",
+	"hn":               "hackernews",
+}
+
+// NormaliseProofService lowercases a proof service name and resolves the keybase proof types and aliases
+func NormaliseProofService(service string) string {
+
+	s := strings.ToLower(strings.TrimSpace(service))
+	if a, ok := proofServiceAliases[s]; ok {
+
+		return a
+	}
+	return s
+}
+
+// ProofsLookup is used to lookup the identity proofs of users using the keybase API
+// the results follow the order of the normalised usernames, see NormaliseUsernames
+func (c *Client) ProofsLookup(ctx context.Context, username []string) ([]ProofsResult, error) {
+
+	log.DebugLog.Printf("lookup proofs for username(s): %v", username)
+
+	// step: lookup username's proofs
+	pr, errl := c.lookupProofs(ctx, NormaliseUsernames(username))
+	if errl != nil {
+
+		if unfe, ok := errl.(ErrorUserNotFound); ok {
+
+			log.DebugLog.Printf("received a ErrorUserNotFound error: %v", unfe)
+		}
+		return pr, errl
+	}
+
+	return pr, nil
+}
+
+// lookupProofs uses the keybase API to lookup the given user's identity proofs
+func (c *Client) lookupProofs(ctx context.Context, username []string) ([]ProofsResult, error) {
+
+	users, errfu := c.fetchUsers(ctx, username, "proofs_summary")
+	if errfu != nil && failedValues(errfu) == nil {
+
+		return nil, errfu
+	}
+
+	return proofsResults(username, users, errfu)
+}
+
+// proofsResults builds the identity proofs lookup results of the given usernames from the fetched users
+// errfu is the error of a partially failed fetch, it's returned when any of the given usernames couldn't be fetched
+func proofsResults(username []string, users map[string]*User, errfu error) ([]ProofsResult, error) {
+
+	var userNotFound []string
+	var lookupFailed bool
+
+	failed := failedValues(errfu)
+	results := make([]ProofsResult, 0, len(username))
+	for _, u := range username {
+
+		if errf, ok := failed[u]; ok {
+
+			log.DebugLog.Printf("proofs lookup of user %s failed: %v", u, errf)
+			lookupFailed = true
+			results = append(results, ProofsResult{Username: u, Err: errf})
+			continue
+		}
+
+		ru, ok := users[u]
+		if !ok {
+
+			log.DebugLog.Printf("user %s not found", u)
+			userNotFound = append(userNotFound, u)
+			results = append(results, ProofsResult{Username: u})
+			continue
+		}
+
+		var proofs []*Proof
+		if ru.ProofsSummary != nil {
+
+			proofs = ru.ProofsSummary.All
+		}
+		log.DebugLog.Printf("user %s has %d identity proof(s)", u, len(proofs))
+		results = append(results, ProofsResult{Username: u, Found: true, Proofs: proofs})
+	}
+
+	if lookupFailed {
+
+		return results, errfu
+	}
+	if len(userNotFound) == 0 {
+
+		return results, nil
+	}
+
+	var eunf ErrorUserNotFound
+	eunf.errmsg = fmt.Sprintf("user(s) %v not found", userNotFound)
+	return results, eunf
+}
+
+// CheckProofs checks the looked up identity proofs against the requirements
+// every user must hold a live proof for each of the required services
+// and every expectation must be met by a live proof with the expected handle, any handle when it's empty
+// an ErrorProofViolation listing every unsatisfied requirement is returned when any isn't met
+func CheckProofs(results []ProofsResult, required []string, expected []ProofExpectation) error {
+
+	var violations []ProofViolation
+
+	byUsername := make(map[string]ProofsResult)
+	for _, r := range results {
+
+		byUsername[r.Username] = r
+	}
+
+	for _, r := range results {
+
+		if !r.Found {
+
+			continue
+		}
+		for _, rs := range required {
+
+			rs = NormaliseProofService(rs)
+			if len(liveProofs(r.Proofs, rs)) == 0 {
+
+				violations = append(violations, ProofViolation{Username: r.Username, Service: rs, Problem: fmt.Sprintf("no live %s proof", rs)})
+			}
+		}
+	}
+
+	for _, e := range expected {
+
+		u := strings.ToLower(strings.TrimSpace(e.Username))
+		es := NormaliseProofService(e.Service)
+		r, ok := byUsername[u]
+		if !ok || !r.Found {
+
+			log.DebugLog.Printf("skipping the %s proof expectation of user %s, the user wasn't looked up or found", es, u)
+			continue
+		}
+
+		var handles []string
+		matched := false
+		for _, p := range liveProofs(r.Proofs, es) {
+
+			handles = append(handles, p.Nametag)
+			if strings.TrimSpace(e.Handle) == "" || strings.EqualFold(p.Nametag, strings.TrimSpace(e.Handle)) {
+
+				matched = true
+			}
+		}
+		if matched {
+
+			continue
+		}
+
+		if strings.TrimSpace(e.Handle) == "" {
+
+			violations = append(violations, ProofViolation{Username: u, Service: es, Problem: fmt.Sprintf("no live %s proof", es)})
+			continue
+		}
+
+		problem := fmt.Sprintf("no live %s proof for handle %s", es, e.Handle)
+		if len(handles) > 0 {
+
+			problem = fmt.Sprintf("%s, live %s proof(s) for: %v", problem, es, handles)
+		}
+		violations = append(violations, ProofViolation{Username: u, Service: es, Problem: problem})
+	}
+
+	if len(violations) == 0 {
+
+		return nil
+	}
+
+	var offenders []string
+	seen := make(map[string]bool)
+	for _, v := range violations {
+
+		if !seen[v.Username] {
+
+			seen[v.Username] = true
+			offenders = append(offenders, v.Username)
+		}
+	}
+
+	var epv ErrorProofViolation
+	epv.errmsg = fmt.Sprintf("identity proofs for user(s) %v don't satisfy the requirements", offenders)
+	epv.Violations = violations
+	return epv
+}
+
+// liveProofs returns the live proofs of the given service
+func liveProofs(proofs []*Proof, service string) []*Proof {
+
+	var live []*Proof
+	for _, p := range proofs {
+
+		if p != nil && p.Service() == service && p.Live() {
+
+			live = append(live, p)
+		}
+	}
+
+	return live
+}
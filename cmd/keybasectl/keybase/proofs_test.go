@@ -0,0 +1,139 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keybase
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCheckProofs(t *testing.T) {
+
+	alice := ProofsResult{Username: "alice", Found: true, Proofs: []*Proof{
+		{ProofType: "github", Nametag: "Alice-GH", State: ProofStateOK},
+		{ProofType: "twitter", Nametag: "alice_tw", State: ProofStatePermFailure},
+		{ProofType: "generic_web_site", Nametag: "alice.example.com", State: ProofStateOK},
+	}}
+	bob := ProofsResult{Username: "bob", Found: true}
+	ghost := ProofsResult{Username: "ghost"}
+
+	tests := []struct {
+		name     string
+		results  []ProofsResult
+		required []string
+		expected []ProofExpectation
+		want     []ProofViolation
+	}{
+		{
+			name:     "required proof is live",
+			results:  []ProofsResult{alice},
+			required: []string{"GitHub", "website"},
+		},
+		{
+			name:     "required proof is missing",
+			results:  []ProofsResult{alice, bob},
+			required: []string{"github"},
+			want:     []ProofViolation{{Username: "bob", Service: "github", Problem: "no live github proof"}},
+		},
+		{
+			name:     "required proof isn't live",
+			results:  []ProofsResult{alice},
+			required: []string{"twitter"},
+			want:     []ProofViolation{{Username: "alice", Service: "twitter", Problem: "no live twitter proof"}},
+		},
+		{
+			name:     "users not found aren't checked",
+			results:  []ProofsResult{ghost},
+			required: []string{"github"},
+			expected: []ProofExpectation{{Username: "ghost", Service: "github", Handle: "ghost-gh"}},
+		},
+		{
+			name:     "expected handle matches whatever its case",
+			results:  []ProofsResult{alice},
+			expected: []ProofExpectation{{Username: "Alice", Service: "github", Handle: "alice-gh"}},
+		},
+		{
+			name:     "expected handle doesn't match",
+			results:  []ProofsResult{alice},
+			expected: []ProofExpectation{{Username: "alice", Service: "github", Handle: "mallory-gh"}},
+			want:     []ProofViolation{{Username: "alice", Service: "github", Problem: "no live github proof for handle mallory-gh, live github proof(s) for: [Alice-GH]"}},
+		},
+		{
+			name:     "expected proof is missing",
+			results:  []ProofsResult{alice, bob},
+			expected: []ProofExpectation{{Username: "bob", Service: "reddit", Handle: "bob-rd"}},
+			want:     []ProofViolation{{Username: "bob", Service: "reddit", Problem: "no live reddit proof for handle bob-rd"}},
+		},
+		{
+			name:     "expected proof without a handle is met by any live handle",
+			results:  []ProofsResult{alice},
+			expected: []ProofExpectation{{Username: "alice", Service: "github"}},
+		},
+		{
+			name:     "expected proof without a handle is missing",
+			results:  []ProofsResult{alice},
+			expected: []ProofExpectation{{Username: "alice", Service: "twitter"}},
+			want:     []ProofViolation{{Username: "alice", Service: "twitter", Problem: "no live twitter proof"}},
+		},
+		{
+			name:     "expectations of users not looked up are skipped",
+			results:  []ProofsResult{alice},
+			expected: []ProofExpectation{{Username: "carol", Service: "github", Handle: "carol-gh"}},
+		},
+	}
+
+	for _, tt := range tests {
+
+		errcp := CheckProofs(tt.results, tt.required, tt.expected)
+		if len(tt.want) == 0 {
+
+			if errcp != nil {
+
+				t.Errorf("%s: expected no error, got %v", tt.name, errcp)
+			}
+			continue
+		}
+
+		epv, ok := errcp.(ErrorProofViolation)
+		if !ok {
+
+			t.Errorf("%s: expected an ErrorProofViolation, got %T: %v", tt.name, errcp, errcp)
+			continue
+		}
+		if !reflect.DeepEqual(epv.Violations, tt.want) {
+
+			t.Errorf("%s: expected the violations %+v, got %+v", tt.name, tt.want, epv.Violations)
+		}
+	}
+}
+
+func TestNormaliseProofService(t *testing.T) {
+
+	tests := map[string]string{
+		"GitHub":           "github",
+		" twitter ":        "twitter",
+		"generic_web_site": "web",
+		"https":            "web",
+		"HN":               "hackernews",
+		"dns":              "dns",
+	}
+
+	for service, want := range tests {
+
+		if got := NormaliseProofService(service); got != want {
+
+			t.Errorf("NormaliseProofService(%q): expected %q, got %q", service, want, got)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keybase
+
+// LookupResult is the structured outcome of every check run against a single requested user
+// it's meant to be serialised for downstream tooling, hence the stable json and yaml field names
+type LookupResult struct {
+	// Input is the normalised identity as requested
+	Input string `json:"input" yaml:"input"`
+	// Selector is how the input selects the user: usernames, github, twitter, domain, key_fingerprint
+	Selector Selector `json:"selector" yaml:"selector"`
+	// Username is the keybase username the input resolved to
+	Username string `json:"username,omitempty" yaml:"username,omitempty"`
+	// ID is the keybase user ID
+	ID string `json:"id,omitempty" yaml:"id,omitempty"`
+	// Found is true when keybase knows about the user, it's nil when no check looked the user up
+	Found *bool `json:"found,omitempty" yaml:"found,omitempty"`
+	// KeyFound is true when keybase holds a primary public key for the user, it's nil when the public key wasn't looked up
+	KeyFound *bool `json:"key_found,omitempty" yaml:"key_found,omitempty"`
+	// Fingerprint is the fingerprint of the user's primary public key
+	Fingerprint string `json:"fingerprint,omitempty" yaml:"fingerprint,omitempty"`
+	// Metadata is the free-form information about the user found in the roster file
+	Metadata map[string]string `json:"metadata,omitempty" yaml:"metadata,omitempty"`
+	// Errors lists every failed check for the user
+	Errors []string `json:"errors,omitempty" yaml:"errors,omitempty"`
+}
@@ -0,0 +1,207 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keybase
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultMaxRetries is the default number of times a failed request is retried
+const DefaultMaxRetries = 3
+
+// DefaultRetryBaseDelay is the default delay before the first retry, it doubles on every retry
+const DefaultRetryBaseDelay = 500 * time.Millisecond
+
+// DefaultRetryMaxDelay is the default cap of the delay between two attempts
+const DefaultRetryMaxDelay = 30 * time.Second
+
+// statusRateLimit is the keybase status code denoting a rate limited API call
+const statusRateLimit = 602
+
+// retryDelay returns how long to wait before retrying a request that failed with err on the given attempt, counted from 0
+// false is returned when the failure isn't transient, the retries are exhausted or the server asks to wait longer than the max delay
+func (c *Client) retryDelay(err error, attempt int) (time.Duration, bool) {
+
+	if attempt >= c.MaxRetries {
+
+		return 0, false
+	}
+
+	retryAfter, transient := transientFailure(err)
+	if !transient {
+
+		return 0, false
+	}
+
+	maxDelay := c.RetryMaxDelay
+	if maxDelay <= 0 {
+
+		maxDelay = DefaultRetryMaxDelay
+	}
+	if retryAfter > 0 {
+
+		return retryAfter, retryAfter <= maxDelay
+	}
+
+	// a base delay of 0 retries at once
+	if c.RetryBaseDelay <= 0 {
+
+		return 0, true
+	}
+
+	// exponential backoff with equal jitter: half of the delay is fixed, the other half random
+	// the delay is doubled up to the max delay rather than shifted, a large attempt can't overflow it
+	delay := c.RetryBaseDelay
+	for i := 0; i < attempt && delay < maxDelay; i++ {
+
+		if delay > maxDelay/2 {
+
+			delay = maxDelay
+			break
+		}
+		delay *= 2
+	}
+	if delay > maxDelay {
+
+		delay = maxDelay
+	}
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1)), true
+}
+
+// transientFailure reports whether the error is worth retrying: transient network failures, 5xx and 429 responses and keybase rate limiting
+// the delay asked for by the server through the Retry-After header is returned when there's one
+func transientFailure(err error) (time.Duration, bool) {
+
+	cause := errors.Cause(err)
+	switch e := cause.(type) {
+	case ErrorHTTPStatus:
+		return e.RetryAfter, e.StatusCode >= 500 || e.StatusCode == http.StatusTooManyRequests
+	case ErrorAPIStatus:
+		return e.RetryAfter, e.Code == statusRateLimit || e.HTTPStatus >= 500 || e.HTTPStatus == http.StatusTooManyRequests
+	}
+
+	return 0, transientNetworkFailure(cause)
+}
+
+// transientNetworkFailure reports whether the network error is worth retrying: timeouts, failures to dial or read and responses cut short
+// the other failures, e.g. an untrusted TLS certificate or an invalid url, fail the same way again
+func transientNetworkFailure(err error) bool {
+
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+
+		return true
+	}
+	if ue, ok := err.(*url.Error); ok {
+
+		err = ue.Err
+	}
+	if oe, ok := err.(*net.OpError); ok {
+
+		return oe.Op == "dial" || oe.Op == "read"
+	}
+
+	return err == io.ErrUnexpectedEOF
+}
+
+// parseRetryAfter parses the value of a Retry-After header, either delay seconds or an HTTP date
+// 0 is returned when the header is missing or invalid
+func parseRetryAfter(val string) time.Duration {
+
+	if val == "" {
+
+		return 0
+	}
+	if secs, errat := strconv.Atoi(val); errat == nil && secs >= 0 {
+
+		return time.Duration(secs) * time.Second
+	}
+	if at, errpt := http.ParseTime(val); errpt == nil {
+
+		if d := time.Until(at); d > 0 {
+
+			return d
+		}
+	}
+
+	return 0
+}
+
+// rateLimiter spaces the requests of a Client evenly so that at most a given number of requests per second are sent
+// it's shared by every goroutine using the Client
+type rateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+// wait blocks until the next request is allowed or the context is done
+func (rl *rateLimiter) wait(ctx context.Context) error {
+
+	rl.mu.Lock()
+	now := time.Now()
+	if rl.next.Before(now) {
+
+		rl.next = now
+	}
+	delay := rl.next.Sub(now)
+	rl.next = rl.next.Add(rl.interval)
+	rl.mu.Unlock()
+
+	return sleep(ctx, delay)
+}
+
+// throttle blocks until the rate limit of the client allows another request or the context is done
+// a RateLimit of 0 never blocks
+func (c *Client) throttle(ctx context.Context) error {
+
+	if c.RateLimit <= 0 {
+
+		return ctx.Err()
+	}
+
+	c.limiterOnce.Do(func() {
+
+		c.limiter = &rateLimiter{interval: time.Duration(float64(time.Second) / c.RateLimit)}
+	})
+	return c.limiter.wait(ctx)
+}
+
+// sleep waits for the given delay, it returns the context error when the context is done first
+func sleep(ctx context.Context, delay time.Duration) error {
+
+	if delay <= 0 {
+
+		return ctx.Err()
+	}
+
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
@@ -0,0 +1,149 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keybase
+
+import (
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	pkgerrors "github.com/pkg/errors"
+)
+
+func TestRetryDelay(t *testing.T) {
+
+	transient := ErrorHTTPStatus{StatusCode: 503, Status: "503 Service Unavailable"}
+
+	tests := []struct {
+		name     string
+		client   *Client
+		err      error
+		attempt  int
+		retry    bool
+		min, max time.Duration
+	}{
+		{
+			name:    "a zero base delay retries at once",
+			client:  &Client{MaxRetries: 3},
+			err:     transient,
+			attempt: 2,
+			retry:   true,
+		},
+		{
+			name:    "the retries are exhausted",
+			client:  &Client{MaxRetries: 3, RetryBaseDelay: time.Millisecond},
+			err:     transient,
+			attempt: 3,
+		},
+		{
+			name:    "a failure that isn't transient isn't retried",
+			client:  &Client{MaxRetries: 3, RetryBaseDelay: time.Millisecond},
+			err:     ErrorHTTPStatus{StatusCode: 404, Status: "404 Not Found"},
+			attempt: 0,
+		},
+		{
+			name:    "the first retry waits about the base delay",
+			client:  &Client{MaxRetries: 3, RetryBaseDelay: 100 * time.Millisecond, RetryMaxDelay: time.Second},
+			err:     transient,
+			attempt: 0,
+			retry:   true,
+			min:     50 * time.Millisecond,
+			max:     100 * time.Millisecond,
+		},
+		{
+			name:    "the delay doubles on every retry",
+			client:  &Client{MaxRetries: 3, RetryBaseDelay: 100 * time.Millisecond, RetryMaxDelay: time.Second},
+			err:     transient,
+			attempt: 2,
+			retry:   true,
+			min:     200 * time.Millisecond,
+			max:     400 * time.Millisecond,
+		},
+		{
+			name:    "a large attempt doesn't overflow the delay",
+			client:  &Client{MaxRetries: 1000, RetryBaseDelay: 5 * time.Nanosecond, RetryMaxDelay: time.Second},
+			err:     transient,
+			attempt: 999,
+			retry:   true,
+			min:     500 * time.Millisecond,
+			max:     time.Second,
+		},
+		{
+			name:    "the Retry-After header wins",
+			client:  &Client{MaxRetries: 3, RetryBaseDelay: time.Millisecond},
+			err:     ErrorHTTPStatus{StatusCode: 429, RetryAfter: 2 * time.Second},
+			attempt: 0,
+			retry:   true,
+			min:     2 * time.Second,
+			max:     2 * time.Second,
+		},
+		{
+			name:    "a Retry-After longer than the max delay fails the request",
+			client:  &Client{MaxRetries: 3, RetryBaseDelay: time.Millisecond, RetryMaxDelay: time.Second},
+			err:     ErrorHTTPStatus{StatusCode: 429, RetryAfter: 2 * time.Second},
+			attempt: 0,
+		},
+	}
+
+	for _, tt := range tests {
+
+		delay, retry := tt.client.retryDelay(tt.err, tt.attempt)
+		if retry != tt.retry {
+
+			t.Errorf("%s: expected retry %v, got %v", tt.name, tt.retry, retry)
+			continue
+		}
+		if retry && (delay < tt.min || delay > tt.max) {
+
+			t.Errorf("%s: expected a delay within [%s, %s], got %s", tt.name, tt.min, tt.max, delay)
+		}
+	}
+}
+
+func TestTransientFailure(t *testing.T) {
+
+	tests := []struct {
+		name       string
+		err        error
+		transient  bool
+		retryAfter time.Duration
+	}{
+		{name: "5xx response", err: ErrorHTTPStatus{StatusCode: 502}, transient: true},
+		{name: "429 response", err: ErrorHTTPStatus{StatusCode: 429, RetryAfter: 3 * time.Second}, transient: true, retryAfter: 3 * time.Second},
+		{name: "4xx response", err: ErrorHTTPStatus{StatusCode: 404}},
+		{name: "keybase rate limiting", err: ErrorAPIStatus{Code: statusRateLimit, HTTPStatus: 200}, transient: true},
+		{name: "keybase input error", err: ErrorAPIStatus{Code: 100, HTTPStatus: 200}},
+		{name: "dial error", err: &url.Error{Op: "Get", URL: "https://keybase.io", Err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}}, transient: true},
+		{name: "read error", err: &net.OpError{Op: "read", Err: errors.New("connection reset by peer")}, transient: true},
+		{name: "write error", err: &net.OpError{Op: "write", Err: errors.New("broken pipe")}},
+		{name: "timeout", err: &url.Error{Op: "Get", URL: "https://keybase.io", Err: &net.DNSError{Err: "i/o timeout", IsTimeout: true}}, transient: true},
+		{name: "response cut short", err: &url.Error{Op: "Get", URL: "https://keybase.io", Err: io.ErrUnexpectedEOF}, transient: true},
+		{name: "untrusted certificate", err: &url.Error{Op: "Get", URL: "https://keybase.io", Err: x509.UnknownAuthorityError{}}},
+		{name: "wrapped 5xx response", err: pkgerrors.Wrap(ErrorHTTPStatus{StatusCode: 503}, "lookup"), transient: true},
+		{name: "other error", err: errors.New("boom")},
+	}
+
+	for _, tt := range tests {
+
+		retryAfter, transient := transientFailure(tt.err)
+		if transient != tt.transient || retryAfter != tt.retryAfter {
+
+			t.Errorf("%s: expected (%s, %v), got (%s, %v)", tt.name, tt.retryAfter, tt.transient, retryAfter, transient)
+		}
+	}
+}
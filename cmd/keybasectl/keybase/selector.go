@@ -0,0 +1,227 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keybase
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	log "github.com/stefancocora/keybasectl/internal/log"
+)
+
+// A Selector is the query parameter the keybase user lookup API selects users with
+type Selector string
+
+// These constants provide the selectors supported by the user lookup API.
+const (
+	SelectorUsername    Selector = "usernames"
+	SelectorGithub      Selector = "github"
+	SelectorTwitter     Selector = "twitter"
+	SelectorDomain      Selector = "domain"
+	SelectorFingerprint Selector = "key_fingerprint"
+)
+
+// ResolveResult is the outcome of resolving a single external identity to a keybase user
+type ResolveResult struct {
+	// Input is the normalised external identity as requested
+	Input string
+	// Found is true when the identity resolved to a keybase user
+	Found bool
+	// Username is the keybase username the identity resolved to
+	Username string
+	// User holds the keybase user when found
+	User *User
+	// Err is the error of the failed lookup of the identity, Found is meaningless when it's set
+	Err error
+}
+
+// normalise normalises the selector values the way NormaliseUsernames does, fingerprints are also stripped of whitespace
+func (sel Selector) normalise(values []string) []string {
+
+	if sel != SelectorFingerprint {
+
+		return NormaliseUsernames(values)
+	}
+
+	var fingerprints []string
+	for _, v := range values {
+
+		fingerprints = append(fingerprints, NormaliseFingerprint(v))
+	}
+	return NormaliseUsernames(fingerprints)
+}
+
+// fields returns the fields to request from the API, adding the ones needed to correlate the users with the selector values
+func (sel Selector) fields(fields []string) []string {
+
+	all := []string{"basics"}
+	switch sel {
+	case SelectorGithub, SelectorTwitter, SelectorDomain:
+		all = append(all, "proofs_summary")
+	case SelectorFingerprint:
+		all = append(all, "public_keys")
+	}
+
+	for _, f := range fields {
+
+		dup := false
+		for _, a := range all {
+
+			dup = dup || a == f
+		}
+		if !dup {
+
+			all = append(all, f)
+		}
+	}
+
+	return all
+}
+
+// identities returns the identities of the user the selector values are matched against
+func (sel Selector) identities(u *User) []string {
+
+	var ids []string
+
+	switch sel {
+	case SelectorUsername:
+		ids = append(ids, strings.ToLower(u.Basics.Username))
+	case SelectorGithub, SelectorTwitter, SelectorDomain:
+		if u.ProofsSummary == nil {
+
+			break
+		}
+		for _, p := range u.ProofsSummary.All {
+
+			if p == nil {
+
+				continue
+			}
+			s := p.Service()
+			if s == string(sel) || (sel == SelectorDomain && (s == "web" || s == "dns")) {
+
+				ids = append(ids, strings.ToLower(p.Nametag))
+			}
+		}
+	case SelectorFingerprint:
+		if u.PublicKeys != nil && u.PublicKeys.Primary != nil {
+
+			ids = append(ids, NormaliseFingerprint(u.PublicKeys.Primary.Fingerprint))
+		}
+	}
+
+	return ids
+}
+
+// correlate matches the returned users with the requested selector values
+// users are matched on their identities first, keybase returns the users in the order of the values
+// so for the reverse selectors a user whose identities don't match falls back to its position when every value got an entry
+// a username is always among the identities of its user, a username lookup is never correlated by position
+func (sel Selector) correlate(values []string, users []*User) map[string]*User {
+
+	byValue := make(map[string]*User)
+	requested := make(map[string]bool)
+	for _, v := range values {
+
+		requested[v] = true
+	}
+
+	var uncorrelated []int
+	for i, u := range users {
+
+		if u == nil {
+
+			continue
+		}
+
+		matched := false
+		for _, id := range sel.identities(u) {
+
+			if requested[id] {
+
+				byValue[id] = u
+				matched = true
+			}
+		}
+		if !matched {
+
+			uncorrelated = append(uncorrelated, i)
+		}
+	}
+
+	if sel != SelectorUsername && len(users) == len(values) {
+
+		for _, i := range uncorrelated {
+
+			if _, ok := byValue[values[i]]; !ok {
+
+				log.DebugLog.Printf("%s %s correlated by position with user %s", sel, values[i], users[i].Basics.Username)
+				byValue[values[i]] = users[i]
+			}
+		}
+	}
+
+	return byValue
+}
+
+// ResolveUsers is used to resolve external identities to keybase users using the keybase API
+// the results follow the order of the normalised values
+func (c *Client) ResolveUsers(ctx context.Context, sel Selector, values []string) ([]ResolveResult, error) {
+
+	var notFound []string
+
+	values = sel.normalise(values)
+	log.DebugLog.Printf("resolve %s identities: %v", sel, values)
+
+	users, errfu := c.fetchCached(ctx, sel, values)
+	failed := failedValues(errfu)
+	if errfu != nil && failed == nil {
+
+		return nil, errfu
+	}
+
+	results := make([]ResolveResult, 0, len(values))
+	for _, v := range values {
+
+		if errf, ok := failed[v]; ok {
+
+			log.DebugLog.Printf("lookup of %s %s failed: %v", sel, v, errf)
+			results = append(results, ResolveResult{Input: v, Err: errf})
+		} else if u, ok := users[v]; ok {
+
+			log.DebugLog.Printf("%s %s resolved to user %s", sel, v, u.Basics.Username)
+			results = append(results, ResolveResult{Input: v, Found: true, Username: strings.ToLower(u.Basics.Username), User: u})
+		} else {
+
+			log.DebugLog.Printf("%s %s not found", sel, v)
+			notFound = append(notFound, v)
+			results = append(results, ResolveResult{Input: v})
+		}
+	}
+
+	// the failed lookups win over the identities not found, they're reported in the results either way
+	if errfu != nil {
+
+		return results, errfu
+	}
+	if len(notFound) == 0 {
+
+		return results, nil
+	}
+
+	var eunf ErrorUserNotFound
+	eunf.errmsg = fmt.Sprintf("%s identity(ies) %v not found", sel, notFound)
+	return results, eunf
+}
@@ -0,0 +1,115 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keybase
+
+import (
+	"testing"
+)
+
+// testUser returns a keybase user holding the given username
+func testUser(username string) *User {
+
+	return &User{ID: username + "-id", Basics: Basics{Username: username}}
+}
+
+// testProver returns a keybase user holding the given username and a live proof for the given service and handle
+func testProver(username, service, handle string) *User {
+
+	u := testUser(username)
+	u.ProofsSummary = &ProofsSummary{All: []*Proof{{ProofType: service, Nametag: handle, State: ProofStateOK}}}
+	return u
+}
+
+func TestCorrelate(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		sel    Selector
+		values []string
+		users  []*User
+		want   map[string]string // selector value -> username
+	}{
+		{
+			name:   "usernames match whatever their order",
+			sel:    SelectorUsername,
+			values: []string{"alice", "bob"},
+			users:  []*User{testUser("Bob"), testUser("alice")},
+			want:   map[string]string{"alice": "alice", "bob": "Bob"},
+		},
+		{
+			name:   "null entries match nothing",
+			sel:    SelectorUsername,
+			values: []string{"alice", "ghost"},
+			users:  []*User{testUser("alice"), nil},
+			want:   map[string]string{"alice": "alice"},
+		},
+		{
+			name:   "usernames aren't correlated by position",
+			sel:    SelectorUsername,
+			values: []string{"alice"},
+			users:  []*User{testUser("mallory")},
+			want:   map[string]string{},
+		},
+		{
+			name:   "github handles match the proofs",
+			sel:    SelectorGithub,
+			values: []string{"bob-gh", "alice-gh"},
+			users:  []*User{testProver("alice", "github", "Alice-GH"), testProver("bob", "github", "bob-gh")},
+			want:   map[string]string{"alice-gh": "alice", "bob-gh": "bob"},
+		},
+		{
+			name:   "github handles fall back to the position",
+			sel:    SelectorGithub,
+			values: []string{"alice-gh", "bob-gh"},
+			users:  []*User{testUser("alice"), testProver("bob", "github", "bob-gh")},
+			want:   map[string]string{"alice-gh": "alice", "bob-gh": "bob"},
+		},
+		{
+			name:   "no position fallback without an entry per value",
+			sel:    SelectorTwitter,
+			values: []string{"alice-tw", "bob-tw"},
+			users:  []*User{testUser("alice")},
+			want:   map[string]string{},
+		},
+		{
+			name:   "domains match the web and dns proofs",
+			sel:    SelectorDomain,
+			values: []string{"alice.example.com"},
+			users:  []*User{testProver("alice", "dns", "alice.example.com")},
+			want:   map[string]string{"alice.example.com": "alice"},
+		},
+	}
+
+	for _, tt := range tests {
+
+		got := tt.sel.correlate(tt.values, tt.users)
+		if len(got) != len(tt.want) {
+
+			t.Errorf("%s: expected %d correlated value(s), got %d", tt.name, len(tt.want), len(got))
+		}
+		for v, username := range tt.want {
+
+			u, ok := got[v]
+			if !ok {
+
+				t.Errorf("%s: expected %s to correlate with user %s, it didn't", tt.name, v, username)
+				continue
+			}
+			if u.Basics.Username != username {
+
+				t.Errorf("%s: expected %s to correlate with user %s, got %s", tt.name, v, username, u.Basics.Username)
+			}
+		}
+	}
+}
@@ -14,23 +14,24 @@ limitations under the License.
 package main
 
 import (
-	"flag"
+	"context"
 	"fmt"
 	"io/ioutil"
-	stdlog "log"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
+	"github.com/pkg/errors"
 	"github.com/stefancocora/keybasectl/cmd/keybasectl/keybase"
 	log "github.com/stefancocora/keybasectl/internal/log"
-	"github.com/stefancocora/keybasectl/internal/version"
 )
 
-var debug bool
-
 //---
 
 // userFlag is the struct that get populated when the --auth cli flag is provided
+// it's repeatable, every occurrence adds a comma separated list of users or, given -, the users read from stdin
 type userFlag struct {
 	set   bool
 	value []string
@@ -38,7 +39,18 @@ type userFlag struct {
 
 func (us *userFlag) Set(val string) error {
 
-	us.value = strings.Split(val, ",")
+	if val == stdinUsers {
+
+		users, errr := readStdinUsers()
+		if errr != nil {
+
+			return errr
+		}
+		us.value = append(us.value, users...)
+	} else {
+
+		us.value = append(us.value, strings.Split(val, ",")...)
+	}
 	us.set = true
 	return nil
 }
@@ -48,149 +60,486 @@ func (us *userFlag) String() string {
 	return fmt.Sprintf("%v", us.value)
 }
 
-var usfL userFlag
 var usEnv = "KEYBASECTL_USER"
-var usUsage = fmt.Sprintf("Comma separated list of user(s) to lookup. Alternatively sourced from %s <required>", usEnv)
+var usUsage = fmt.Sprintf("Comma separated list of user(s) to lookup, - reads a newline or comma separated list from stdin. Repeatable. Alternatively sourced from %s <required>", usEnv)
 var usName = "user"
 
+// stdinUsers is the --user value reading the users from stdin
+const stdinUsers = "-"
+
+// stdinRead is set once the users were read from stdin, it can't be read twice
+var stdinRead bool
+
+// readStdinUsers reads a list of users from stdin, see parseUserList
+func readStdinUsers() ([]string, error) {
+
+	if stdinRead {
+
+		return nil, errors.New("the users can only be read from stdin once")
+	}
+	stdinRead = true
+
+	b, errr := ioutil.ReadAll(os.Stdin)
+	if errr != nil {
+
+		return nil, errors.Wrap(errr, "unable to read the users from stdin")
+	}
+
+	return parseUserList(string(b)), nil
+}
+
+// parseUserList returns the users of a newline or comma separated list
+// blank lines and comments, from # to the end of the line, are ignored
+func parseUserList(list string) []string {
+
+	var users []string
+	for _, line := range strings.Split(list, "\n") {
+
+		if i := strings.Index(line, "#"); i >= 0 {
+
+			line = line[:i]
+		}
+		for _, u := range strings.Split(line, ",") {
+
+			if u = strings.TrimSpace(u); u != "" {
+
+				users = append(users, u)
+			}
+		}
+	}
+
+	return users
+}
+
+//---
+
+// userFileFlag is the struct that get populated when the --user-file cli flag is provided
+// it's repeatable, every occurrence adds the users listed in a file to the --user ones
+type userFileFlag struct {
+	users *userFlag
+}
+
+func (uff *userFileFlag) Set(val string) error {
+
+	b, errrf := ioutil.ReadFile(val)
+	if errrf != nil {
+
+		return errors.Wrapf(errrf, "unable to read the user file: %s", val)
+	}
+
+	uff.users.value = append(uff.users.value, parseUserList(string(b))...)
+	uff.users.set = true
+	return nil
+}
+
+func (uff *userFileFlag) String() string {
+
+	return ""
+}
+
+var usFileUsage = "File listing user(s) to lookup, newline or comma separated, blank lines and # comments are ignored. Adds up with --user. Repeatable"
+var usFileName = "user-file"
+
+//---
+
+// identityFlag binds a cli flag to the keybase lookup selector resolving its external identities to keybase users
+type identityFlag struct {
+	name     string
+	selector keybase.Selector
+	usage    string
+	value    userFlag
+}
+
+// newIdentityFlags returns the external identity flags, unset
+func newIdentityFlags() []*identityFlag {
+
+	return []*identityFlag{
+		{name: "github", selector: keybase.SelectorGithub, usage: "Comma separated list of GitHub usernames to resolve to keybase user(s), - reads a newline or comma separated list from stdin. Repeatable"},
+		{name: "twitter", selector: keybase.SelectorTwitter, usage: "Comma separated list of Twitter handles to resolve to keybase user(s), - reads a newline or comma separated list from stdin. Repeatable"},
+		{name: "domain", selector: keybase.SelectorDomain, usage: "Comma separated list of web or DNS domains to resolve to keybase user(s), - reads a newline or comma separated list from stdin. Repeatable"},
+		{name: "fingerprint", selector: keybase.SelectorFingerprint, usage: "Comma separated list of PGP key fingerprints to resolve to keybase user(s), - reads a newline or comma separated list from stdin. Repeatable"},
+	}
+}
+
 //---
 
 // apiEndpointFlag is the struct that get populated when the --api cli flag is provided
-// this switches the keybase endpoint to either their prod or staging API endpoints
-// Disabled for now since the staging URL from the keybase client doesn't DNS resolve
-// type apiEndpointFlag struct {
-// 	set   bool
-// 	value string
-// }
+// this switches the keybase endpoint to either their prod or staging API endpoints or to any other base URL
+type apiEndpointFlag struct {
+	set   bool
+	value string
+}
 
-// func (us *apiEndpointFlag) Set(val string) error {
+func (us *apiEndpointFlag) Set(val string) error {
 
-// 	us.value = val
-// 	us.set = true
-// 	return nil
-// }
+	us.value = val
+	us.set = true
+	return nil
+}
 
-// func (us *apiEndpointFlag) String() string {
+func (us *apiEndpointFlag) String() string {
 
-// 	return us.value
-// }
+	return us.value
+}
 
-// var apifL apiEndpointFlag
-// var apiEnv = "KEYBASECTL_API_ENDPOINT"
-// var apiUsage = fmt.Sprintf("Keybase API endpoint to target. Default to [production]. Alternatively sourced from %s. Disabled for now since the staging URL from the keybase client doesn't DNS resolve", apiEnv)
-// var apiName = "api"
+var apiEnv = "KEYBASECTL_API_ENDPOINT"
+var apiUsage = fmt.Sprintf("Keybase API endpoint to target, one of [production staging] or a base URL like http://localhost:8080. Default to [production]. Alternatively sourced from %s", apiEnv)
+var apiName = "api"
 
 //---
 
-func init() {
+// fingerprintFlag is the struct that get populated when the --expect-fingerprint cli flag is provided
+// it's repeatable, every occurrence pins the public key fingerprint of a user: user=FPR
+type fingerprintFlag struct {
+	set   bool
+	value map[string]string
+}
+
+func (fp *fingerprintFlag) Set(val string) error {
+
+	u, fpr, errp := parseFingerprintPin(val)
+	if errp != nil {
+
+		return errp
+	}
+	if fp.value == nil {
+
+		fp.value = make(map[string]string)
+	}
+	fp.value[u] = fpr
+	fp.set = true
+	return nil
+}
+
+func (fp *fingerprintFlag) String() string {
+
+	return fmt.Sprintf("%v", fp.value)
+}
+
+var fpUsage = "Pin the public key fingerprint of a user as user=FPR, the public key lookup fails unless keybase returns that fingerprint. Repeatable"
+var fpName = "expect-fingerprint"
+
+var fpFileUsage = "File holding one user=FPR fingerprint pin per line, blank lines and lines starting with # are ignored"
+var fpFileName = "expect-fingerprint-file"
+
+//---
+
+// requireProofFlag is the struct that get populated when the --require-proof cli flag is provided
+// it's repeatable and every occurrence accepts a comma separated list of proof services
+type requireProofFlag struct {
+	set   bool
+	value []string
+}
+
+func (rp *requireProofFlag) Set(val string) error {
+
+	for _, s := range strings.Split(val, ",") {
+
+		if s = strings.TrimSpace(s); s != "" {
+
+			rp.value = append(rp.value, s)
+		}
+	}
+	rp.set = true
+	return nil
+}
+
+func (rp *requireProofFlag) String() string {
+
+	return fmt.Sprintf("%v", rp.value)
+}
+
+var rpUsage = "Comma separated list of identity proof services every user must hold a live proof for: github, twitter, reddit, hackernews, web, dns. Repeatable"
+var rpName = "require-proof"
+
+//---
+
+// expectProofFlag is the struct that get populated when the --expect-proof cli flag is provided
+// it's repeatable, every occurrence expects a live proof for a handle: user=service:handle
+type expectProofFlag struct {
+	set   bool
+	value []keybase.ProofExpectation
+}
+
+func (ep *expectProofFlag) Set(val string) error {
 
-	flag.BoolVar(&debug, "debug", false, "turn on debugging")
-	// flag.Var(&apifL, apiName, apiUsage)
-	flag.Var(&usfL, usName, usUsage)
+	parts := strings.SplitN(val, "=", 2)
+	if len(parts) == 2 {
 
+		sh := strings.SplitN(parts[1], ":", 2)
+		if len(sh) == 2 && strings.TrimSpace(parts[0]) != "" && strings.TrimSpace(sh[0]) != "" && strings.TrimSpace(sh[1]) != "" {
+
+			ep.value = append(ep.value, keybase.ProofExpectation{Username: parts[0], Service: sh[0], Handle: sh[1]})
+			ep.set = true
+			return nil
+		}
+	}
+
+	return errors.Errorf("invalid proof expectation %q, expected user=service:handle", val)
+}
+
+func (ep *expectProofFlag) String() string {
+
+	return fmt.Sprintf("%v", ep.value)
 }
 
+var epUsage = "Expect a user to hold a live identity proof for a handle as user=service:handle, e.g. alice=github:alice-gh. Repeatable"
+var epName = "expect-proof"
+
+//---
+
+var validateKeysUsage = "Parse the users' PGP public keys locally and fail on fingerprint mismatches, revoked, expired or weak keys"
+var validateKeysName = "validate-keys"
+
+var minRSABitsUsage = "Minimum RSA/DSA/ElGamal key size accepted by --validate-keys"
+var minRSABitsName = "min-rsa-bits"
+
+var expiryWindowDaysUsage = "Make --validate-keys fail keys or encryption subkeys expiring within that many days, 0 only fails expired keys"
+var expiryWindowDaysName = "expiry-window-days"
+
+var outputUsage = "Output format, one of [text json yaml]. json and yaml emit a single document with a result per user"
+var outputName = "output"
+
+var rptUsage = fmt.Sprintf("Write a report file as format=path, e.g. %s=keybasectl.xml for a JUnit XML report with a test case per user lookup and public key check. Repeatable", reportJUnit)
+var rptName = "report"
+
+var chunkSizeUsage = "Maximum number of users looked up by a single keybase API request, larger lookups are split in chunks"
+var chunkSizeName = "chunk-size"
+
+var concurrencyUsage = "Maximum number of keybase API requests in flight at once when a lookup is split in chunks"
+var concurrencyName = "concurrency"
+
+var retriesUsage = "Number of times a keybase API request failing with a timeout, a failed connection or read, a 5xx response or rate limiting is retried, 0 disables the retries"
+var retriesName = "retries"
+
+var retryDelayUsage = "Delay before the first retry, it doubles on every retry and is jittered, 0 retries at once, a Retry-After header from keybase wins"
+var retryDelayName = "retry-delay"
+
+var rateLimitUsage = "Maximum number of keybase API requests sent per second, 0 means unlimited"
+var rateLimitName = "rate-limit"
+
+var timeoutUsage = "Overall deadline of the run, e.g. 2m, the checks done when it elapses are reported. 0 means no deadline"
+var timeoutName = "timeout"
+
+var requestTimeoutUsage = "Deadline of every single keybase API request, 0 means no deadline"
+var requestTimeoutName = "request-timeout"
+
+var cacheDirUsage = "Directory caching the keybase lookups. Default to the user cache directory, $XDG_CACHE_HOME/keybasectl on linux"
+var cacheDirName = "cache-dir"
+
+var cacheTTLUsage = "Time a cached keybase lookup is served for without a request, e.g. 1h. 0 disables the cache"
+var cacheTTLName = "cache-ttl"
+
+var offlineUsage = "Serve the keybase lookups from the cache only, whatever their age, a lookup missing from the cache fails"
+var offlineName = "offline"
+
+var rosterUsage = "Roster file, YAML or JSON, listing the team members with their expected username, pinned fingerprint and required proofs. Replaces --user and the external identity flags"
+var rosterName = "roster"
+var rosterShortName = "f"
+
+var cfgEnv = "KEYBASECTL_CONFIG"
+var cfgUsage = fmt.Sprintf("YAML config file defaulting the flags, the environment variables and the flags win over it. Default to the user config directory, $XDG_CONFIG_HOME/keybasectl/config.yaml on linux. Alternatively sourced from %s", cfgEnv)
+var cfgName = "config"
+
+//---
+
 func main() {
 
-	var exitVal = 0
-	var errl, errpkl error
-	var kbFl keybase.DebugFlag
-	var uf, unf []string // captures the users found and not found
-	var kf, knf []string // captures the user's pubkey found and not found
+	os.Exit(dispatch(os.Args[1:]))
+}
+
+// runContext returns the context of a run, it's cancelled on SIGINT/SIGTERM and once the timeout elapses
+// a timeout of 0 means no deadline
+func runContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	if timeout <= 0 {
+
+		return ctx, stop
+	}
+
+	tctx, cancel := context.WithTimeout(ctx, timeout)
+	return tctx, func() {
+
+		cancel()
+		stop()
+	}
+}
+
+// interruptReason describes why the run got cut short from the context error
+func interruptReason(errctx error) string {
 
-	if !flag.Parsed() {
+	if errctx == context.DeadlineExceeded {
 
-		flag.Parse()
+		return fmt.Sprintf("timed out, flag: \"%s\"", timeoutName)
 	}
+	return "interrupted"
+}
+
+// loggingSetup initialises the logging writers according to the debug flag
+func loggingSetup(debug bool) {
 
-	// logging setup
 	if debug {
 		log.LoggingInit(os.Stdout, "short", os.Stderr, "short", os.Stderr, "short")
 	} else {
 		log.LoggingInit(ioutil.Discard, "short", ioutil.Discard, "short", ioutil.Discard, "short")
 	}
+}
+
+// resolveUsers returns the users to lookup, the --user flag wins over the KEYBASECTL_USER envvar
+// which wins over the default users of the config file
+// an error is returned when none is set
+// the check commands don't call it when only external identity flags are given, they select the users on their own
+func resolveUsers(uf userFlag, cfgUsers []string) ([]string, error) {
 
-	log.InfoLog.Println("starting engines")
+	use, okOaEnv := os.LookupEnv(usEnv)
+	log.DebugLog.Printf("environment variable lookup result: %s", use)
+	log.DebugLog.Printf("cli flag: %s set to: %s, set: %v", usName, uf.value, uf.set)
 
-	_, err := version.BuildContext()
-	if err != nil {
+	if uf.set {
 
-		stdlog.Fatalf("[FATAL] unable to get the binary version: %v", err)
+		return uf.value, nil
 	}
+	if okOaEnv {
 
-	// step: check required flag/envvar
-	use, okOaEnv := os.LookupEnv(usEnv)
-	log.DebugLog.Printf("environment variable lookup result: %s", use)
-	log.DebugLog.Printf("cli flag: %s set to: %s, set: %v", usName, usfL.value, usfL.set)
-	if !usfL.set && !okOaEnv {
+		return strings.Split(use, ","), nil
+	}
+	if len(cfgUsers) > 0 {
+
+		log.DebugLog.Printf("config file users: %v", cfgUsers)
+		return cfgUsers, nil
+	}
+
+	return nil, errors.Errorf("required flag or environment variable not set! flag: %s, environmentVariable: %v", usName, usEnv)
+}
+
+// resolveAPIEndpoint returns the keybase API base URL, the --api flag wins over the KEYBASECTL_API_ENDPOINT envvar
+func resolveAPIEndpoint(af apiEndpointFlag) (string, error) {
 
-		log.ErrorLog.Printf("required flag or environment variable not set! flag: %s, environmentVariable: %v", usName, usEnv)
-		fmt.Fprintf(os.Stdout, "required flag or environment variable not set! flag: \"%s\", environmentVariable: \"%v\"\n", usName, usEnv)
-		exitVal++
-		goto exitAll
+	var apiTarget string
+
+	if ape, okApEnv := os.LookupEnv(apiEnv); okApEnv {
+
+		apiTarget = ape
 	}
+	if af.set {
 
-	log.DebugLog.Printf("--user flag arguments: %#v", flag.Args())
+		apiTarget = af.value
+	}
+	log.DebugLog.Printf("cli flag: %s set to: %s, set: %v", apiName, af.value, af.set)
 
-	kbFl.NewDebugFlag(debug)
-	log.DebugLog.Printf("current setting for the debug flag inside the keybase pkg: %v", kbFl.DebugSetting())
+	return keybase.ResolveBaseURL(apiTarget)
+}
 
-	// step: lookup user against keybase
-	uf, unf, errl = keybase.UserLookup(usfL.value)
-	if errl != nil {
+// splitUserResults splits the user lookup results into the usernames found and not found
+// the usernames whose lookup failed are in neither
+func splitUserResults(results []keybase.UserResult) ([]string, []string) {
 
-		exitVal++
-		if _, ok := errl.(keybase.ErrorUserNotFound); ok {
+	var found, notFound []string
+	for _, r := range results {
 
-			if len(uf) > 0 {
-				fmt.Fprintf(os.Stdout, "user(s): %v found during keybase lookup\n", uf)
-			}
-			fmt.Fprintf(os.Stdout, "user(s): %v not found during keybase lookup\n", unf)
-			log.ErrorLog.Printf("error during keybase user lookup: %s", errl.Error())
-			goto exitAll
+		if r.Err != nil {
+
+			continue
+		}
+		if r.Found {
+
+			found = append(found, r.Username)
 		} else {
 
-			fmt.Fprintf(os.Stdout, "error : %s\n", errl.Error())
-			log.ErrorLog.Printf("error : %s", errl.Error())
-			goto exitAll
+			notFound = append(notFound, r.Username)
 		}
-	} else {
-		fmt.Fprintf(os.Stdout, "user(s): %v found during keybase lookup\n", uf)
 	}
 
-	// step: lookup user's pubkey against keybase
-	kf, knf, errpkl = keybase.PubKeyLookup(usfL.value)
-	if errpkl != nil {
+	return found, notFound
+}
 
-		exitVal++
-		if _, ok := errpkl.(keybase.ErrorPKNotFound); ok {
+// splitPubKeyResults splits the public key lookup results into the usernames with and without a public key
+// the usernames whose lookup failed are in neither
+func splitPubKeyResults(results []keybase.PubKeyResult) ([]string, []string) {
 
-			if len(kf) > 0 {
+	var found, notFound []string
+	for _, r := range results {
 
-				fmt.Fprintf(os.Stdout, "user(s): %v public key found during keybase public key lookup\n", kf)
-			}
-			fmt.Fprintf(os.Stdout, "user(s): %v public key not found during keybase public key lookup\n", knf)
-			log.ErrorLog.Printf("error during keybase public key lookup: %s", errpkl.Error())
-			goto exitAll
+		if r.Err != nil {
+
+			continue
+		}
+		if r.Found {
+
+			found = append(found, r.Username)
 		} else {
 
-			fmt.Fprintf(os.Stdout, "error : %s\n", errpkl.Error())
-			log.ErrorLog.Printf("error : %s", errpkl.Error())
-			goto exitAll
+			notFound = append(notFound, r.Username)
 		}
-	} else {
-		fmt.Fprintf(os.Stdout, "user(s): %v public key found during keybase public key lookup\n", kf)
+	}
+
+	return found, notFound
+}
 
+// parseFingerprintPin parses a user=FPR fingerprint pin
+func parseFingerprintPin(val string) (string, string, error) {
+
+	parts := strings.SplitN(val, "=", 2)
+	if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+
+		return "", "", errors.Errorf("invalid fingerprint pin %q, expected user=FPR", val)
 	}
 
-exitAll:
-	if exitVal > 0 {
+	return strings.ToLower(strings.TrimSpace(parts[0])), keybase.NormaliseFingerprint(parts[1]), nil
+}
 
-		log.InfoLog.Println("stopping engines, we're done")
-		os.Exit(1)
-	} else {
+// resolveFingerprintPins merges the fingerprint pins from the pin file and the --expect-fingerprint flags
+// the flags win over the file when a user is pinned in both
+func resolveFingerprintPins(ff fingerprintFlag, path string) (map[string]string, error) {
+
+	pins := make(map[string]string)
+
+	if path != "" {
+
+		b, errrf := ioutil.ReadFile(path)
+		if errrf != nil {
+
+			return nil, errors.Wrapf(errrf, "unable to read the fingerprint pin file: %s", path)
+		}
+
+		for i, line := range strings.Split(string(b), "\n") {
+
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") {
+
+				continue
+			}
+
+			u, fpr, errp := parseFingerprintPin(line)
+			if errp != nil {
+
+				return nil, errors.Wrapf(errp, "%s:%d", path, i+1)
+			}
+			pins[u] = fpr
+		}
+	}
+
+	for u, fpr := range ff.value {
+
+		pins[u] = fpr
+	}
+	log.DebugLog.Printf("fingerprint pins: %v", pins)
 
-		log.InfoLog.Println("stopping engines, we're done")
+	return pins, nil
+}
+
+// pinnedUsers returns the usernames of the looked up keys that carry a fingerprint pin
+func pinnedUsers(results []keybase.PubKeyResult, pins map[string]string) []string {
+
+	var pinned []string
+	for _, r := range results {
+
+		if _, ok := pins[r.Username]; ok {
+
+			pinned = append(pinned, r.Username)
+		}
 	}
 
+	return pinned
 }
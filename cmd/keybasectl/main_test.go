@@ -0,0 +1,196 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseFingerprintPin(t *testing.T) {
+
+	tests := []struct {
+		val  string
+		user string
+		fpr  string
+		err  bool
+	}{
+		{val: "alice=52A458322E924A5106F2562AC17B21BA395A8D3C", user: "alice", fpr: "52a458322e924a5106f2562ac17b21ba395a8d3c"},
+		{val: " Alice = 0x52A4 5832 2E92 4A51 06F2 562A C17B 21BA 395A 8D3C", user: "alice", fpr: "52a458322e924a5106f2562ac17b21ba395a8d3c"},
+		{val: "alice", err: true},
+		{val: "alice=", err: true},
+		{val: "=52A458322E924A5106F2562AC17B21BA395A8D3C", err: true},
+	}
+
+	for _, tt := range tests {
+
+		user, fpr, errp := parseFingerprintPin(tt.val)
+		if (errp != nil) != tt.err {
+
+			t.Errorf("parseFingerprintPin(%q): expected error %v, got %v", tt.val, tt.err, errp)
+			continue
+		}
+		if user != tt.user || fpr != tt.fpr {
+
+			t.Errorf("parseFingerprintPin(%q): expected (%s, %s), got (%s, %s)", tt.val, tt.user, tt.fpr, user, fpr)
+		}
+	}
+}
+
+func TestResolveFingerprintPins(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "pins.txt")
+	pinFile := "# team pins\nalice=52A458322E924A5106F2562AC17B21BA395A8D3C\n\nbob=9F3A4B2C1D0E9F8A7B6C5D4E3F2A1B0C9D8E7F6A\n"
+	if errw := ioutil.WriteFile(path, []byte(pinFile), 0600); errw != nil {
+
+		t.Fatalf("unexpected error: %v", errw)
+	}
+
+	var ff fingerprintFlag
+	if errs := ff.Set("bob=0000000000000000000000000000000000000000"); errs != nil {
+
+		t.Fatalf("unexpected error: %v", errs)
+	}
+
+	pins, errr := resolveFingerprintPins(ff, path)
+	if errr != nil {
+
+		t.Fatalf("unexpected error: %v", errr)
+	}
+	want := map[string]string{
+		"alice": "52a458322e924a5106f2562ac17b21ba395a8d3c",
+		"bob":   "0000000000000000000000000000000000000000",
+	}
+	if !reflect.DeepEqual(pins, want) {
+
+		t.Errorf("expected the flags to win over the pin file %v, got %v", want, pins)
+	}
+
+	if errw := ioutil.WriteFile(path, []byte("alice=52A458322E924A5106F2562AC17B21BA395A8D3C\nbob\n"), 0600); errw != nil {
+
+		t.Fatalf("unexpected error: %v", errw)
+	}
+	if _, errr := resolveFingerprintPins(fingerprintFlag{}, path); errr == nil || !strings.Contains(errr.Error(), path+":2") {
+
+		t.Errorf("expected an error naming the invalid line, got %v", errr)
+	}
+}
+
+func TestParseUserList(t *testing.T) {
+
+	tests := []struct {
+		list string
+		want []string
+	}{
+		{list: "", want: nil},
+		{list: "alice,bob", want: []string{"alice", "bob"}},
+		{list: "alice\nbob\n", want: []string{"alice", "bob"}},
+		{list: "# team\nalice, bob # leads\n\n  carol  \r\n#dave\n", want: []string{"alice", "bob", "carol"}},
+		{list: "alice,,bob,", want: []string{"alice", "bob"}},
+	}
+
+	for _, tt := range tests {
+
+		if got := parseUserList(tt.list); !reflect.DeepEqual(got, tt.want) {
+
+			t.Errorf("parseUserList(%q): expected %v, got %v", tt.list, tt.want, got)
+		}
+	}
+}
+
+// withStdin runs fn with os.Stdin reading the given content
+func withStdin(t *testing.T, content string, fn func()) {
+
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "stdin")
+	if errw := ioutil.WriteFile(path, []byte(content), 0600); errw != nil {
+
+		t.Fatalf("unexpected error: %v", errw)
+	}
+	f, erro := os.Open(path)
+	if erro != nil {
+
+		t.Fatalf("unexpected error: %v", erro)
+	}
+	defer f.Close()
+
+	stdin := os.Stdin
+	os.Stdin = f
+	stdinRead = false
+	defer func() {
+
+		os.Stdin = stdin
+		stdinRead = false
+	}()
+
+	fn()
+}
+
+func TestUserFlagStdin(t *testing.T) {
+
+	withStdin(t, "alice\n# on leave\nbob, carol\n", func() {
+
+		var uf userFlag
+		for _, val := range []string{"dave", stdinUsers} {
+
+			if errs := uf.Set(val); errs != nil {
+
+				t.Fatalf("unexpected error: %v", errs)
+			}
+		}
+		if want := []string{"dave", "alice", "bob", "carol"}; !uf.set || !reflect.DeepEqual(uf.value, want) {
+
+			t.Errorf("expected the users %v, got %v", want, uf.value)
+		}
+
+		if errs := uf.Set(stdinUsers); errs == nil {
+
+			t.Errorf("expected stdin not to be read twice")
+		}
+	})
+}
+
+func TestUserFileFlag(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "users.txt")
+	if errw := ioutil.WriteFile(path, []byte("alice\nbob # backend\n"), 0600); errw != nil {
+
+		t.Fatalf("unexpected error: %v", errw)
+	}
+
+	var uf userFlag
+	uff := userFileFlag{users: &uf}
+	if errs := uf.Set("carol"); errs != nil {
+
+		t.Fatalf("unexpected error: %v", errs)
+	}
+	if errs := uff.Set(path); errs != nil {
+
+		t.Fatalf("unexpected error: %v", errs)
+	}
+	if want := []string{"carol", "alice", "bob"}; !reflect.DeepEqual(uf.value, want) {
+
+		t.Errorf("expected the users %v, got %v", want, uf.value)
+	}
+
+	if errs := uff.Set(filepath.Join(t.TempDir(), "missing.txt")); errs == nil {
+
+		t.Errorf("expected a missing user file to be rejected")
+	}
+}
@@ -0,0 +1,79 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+
+	log "github.com/stefancocora/keybasectl/internal/log"
+	"github.com/stefancocora/keybasectl/internal/mockapi"
+)
+
+// mockServerCmd is the name of the subcommand serving a local mock of the keybase API
+const mockServerCmd = "mock-server"
+
+// mockServer serves the keybase user lookup API from a fixture directory until the process is stopped
+// it returns the process exit value
+func mockServer(args []string) int {
+
+	var msDebug bool
+	var listen, fixtures string
+
+	fs := flag.NewFlagSet(mockServerCmd, flag.ExitOnError)
+	fs.BoolVar(&msDebug, "debug", false, "turn on debugging")
+	fs.StringVar(&listen, "listen", "127.0.0.1:8080", "address to listen on")
+	fs.StringVar(&fixtures, "fixtures", "", "directory holding the <username>.json fixtures to serve <required>")
+	fs.Usage = func() {
+
+		fmt.Fprintf(fs.Output(), "Usage: %s %s --fixtures DIR [--listen ADDR]\n\nServe a mock of the keybase user lookup API, point keybasectl at it with --api http://ADDR\n\n", os.Args[0], mockServerCmd)
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	// logging setup, errors are always shown since this is a long running process
+	if msDebug {
+		log.LoggingInit(os.Stdout, "short", os.Stderr, "short", os.Stderr, "short")
+	} else {
+		log.LoggingInit(os.Stdout, "short", os.Stderr, "short", ioutil.Discard, "short")
+	}
+
+	if fixtures == "" {
+
+		fmt.Fprintf(os.Stdout, "required flag not set! flag: \"%s\"\n", "fixtures")
+		fs.Usage()
+		return exitUsage
+	}
+
+	h, errnh := mockapi.NewHandler(fixtures)
+	if errnh != nil {
+
+		log.ErrorLog.Printf("unable to start the mock keybase API: %v", errnh)
+		fmt.Fprintf(os.Stdout, "error : %s\n", errnh.Error())
+		return exitFailure
+	}
+
+	log.InfoLog.Printf("serving mock keybase API from %s on http://%s%s", fixtures, listen, mockapi.UserLookupPath)
+	if errls := http.ListenAndServe(listen, h); errls != nil {
+
+		log.ErrorLog.Printf("mock keybase API stopped: %v", errls)
+		fmt.Fprintf(os.Stdout, "error : %s\n", errls.Error())
+		return exitFailure
+	}
+
+	return exitOK
+}
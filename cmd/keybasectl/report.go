@@ -0,0 +1,233 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/pkg/errors"
+	"github.com/stefancocora/keybasectl/cmd/keybasectl/keybase"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// the output formats supported by the --output flag
+const (
+	outputText = "text"
+	outputJSON = "json"
+	outputYAML = "yaml"
+)
+
+// report is the single document emitted by the structured output formats
+// it collects a keybase.LookupResult per requested user while the checks run
+type report struct {
+	OK      bool                    `json:"ok" yaml:"ok"`
+	Results []*keybase.LookupResult `json:"results" yaml:"results"`
+	Errors  []string                `json:"errors,omitempty" yaml:"errors,omitempty"`
+}
+
+// addInputs adds a result for every input selecting users with the given selector
+func (r *report) addInputs(sel keybase.Selector, inputs []string) {
+
+	for _, i := range inputs {
+
+		r.Results = append(r.Results, &keybase.LookupResult{Input: i, Selector: sel})
+	}
+}
+
+// byUsername returns the results resolved to the given username
+// a result selecting users by username is added when there's none
+func (r *report) byUsername(username string) []*keybase.LookupResult {
+
+	var results []*keybase.LookupResult
+	for _, lr := range r.Results {
+
+		if lr.Username == username || (lr.Selector == keybase.SelectorUsername && lr.Input == username) {
+
+			results = append(results, lr)
+		}
+	}
+
+	if len(results) == 0 {
+
+		lr := &keybase.LookupResult{Input: username, Selector: keybase.SelectorUsername}
+		r.Results = append(r.Results, lr)
+		results = append(results, lr)
+	}
+
+	return results
+}
+
+// addResolved records the outcome of resolving external identities to keybase users
+func (r *report) addResolved(sel keybase.Selector, rr []keybase.ResolveResult) {
+
+	for _, res := range rr {
+
+		lr := &keybase.LookupResult{Input: res.Input, Selector: sel, Username: res.Username}
+		if res.Err != nil {
+
+			lr.Errors = append(lr.Errors, "identity lookup failed: "+res.Err.Error())
+			r.Results = append(r.Results, lr)
+			continue
+		}
+		lr.Found = checked(res.Found)
+		if !res.Found {
+
+			lr.Errors = append(lr.Errors, "identity not found")
+		}
+		r.Results = append(r.Results, lr)
+	}
+}
+
+// addUsers records the outcome of the user lookup
+func (r *report) addUsers(ur []keybase.UserResult) {
+
+	for _, res := range ur {
+
+		for _, lr := range r.byUsername(res.Username) {
+
+			lr.Username = res.Username
+			if res.Err != nil {
+
+				lr.Errors = append(lr.Errors, "user lookup failed: "+res.Err.Error())
+				continue
+			}
+			lr.Found = checked(res.Found)
+			if res.User != nil {
+
+				lr.ID = res.User.ID
+			}
+			if !res.Found {
+
+				lr.Errors = append(lr.Errors, "user not found")
+			}
+		}
+	}
+}
+
+// addKeys records the outcome of the public key lookup
+func (r *report) addKeys(kr []keybase.PubKeyResult) {
+
+	for _, res := range kr {
+
+		for _, lr := range r.byUsername(res.Username) {
+
+			lr.Username = res.Username
+			if res.Err != nil {
+
+				lr.Errors = append(lr.Errors, "public key lookup failed: "+res.Err.Error())
+				continue
+			}
+			// a public key is only found for a user keybase knows about
+			if res.Found {
+
+				lr.Found = checked(true)
+			}
+			lr.KeyFound = checked(res.Found)
+			if res.Key != nil {
+
+				lr.Fingerprint = keybase.NormaliseFingerprint(res.Key.Fingerprint)
+			}
+			if !res.Found {
+
+				lr.Errors = append(lr.Errors, "public key not found")
+			}
+		}
+	}
+}
+
+// addProofs records the outcome of the identity proofs lookup
+func (r *report) addProofs(pr []keybase.ProofsResult) {
+
+	for _, res := range pr {
+
+		for _, lr := range r.byUsername(res.Username) {
+
+			lr.Username = res.Username
+			if res.Err != nil {
+
+				lr.Errors = append(lr.Errors, "identity proofs lookup failed: "+res.Err.Error())
+				continue
+			}
+			lr.Found = checked(res.Found)
+			if !res.Found {
+
+				lr.Errors = append(lr.Errors, "user not found")
+			}
+		}
+	}
+}
+
+// addMetadata records the roster metadata of the given user
+func (r *report) addMetadata(username string, md map[string]string) {
+
+	for _, lr := range r.byUsername(username) {
+
+		lr.Metadata = md
+	}
+}
+
+// addUserError records a failed check for the given user
+func (r *report) addUserError(username, msg string) {
+
+	for _, lr := range r.byUsername(username) {
+
+		lr.Errors = append(lr.Errors, msg)
+	}
+}
+
+// addError records a failure not tied to a single user
+func (r *report) addError(msg string) {
+
+	r.Errors = append(r.Errors, msg)
+}
+
+// write serialises the report in the given output format
+func (r *report) write(w io.Writer, format string, ok bool) error {
+
+	r.OK = ok
+	if r.Results == nil {
+
+		r.Results = []*keybase.LookupResult{}
+	}
+
+	switch format {
+	case outputJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(r)
+	case outputYAML:
+		b, errm := yaml.Marshal(r)
+		if errm != nil {
+
+			return errm
+		}
+		_, errw := w.Write(b)
+		return errw
+	}
+
+	return errors.Errorf("unsupported output format: %s", format)
+}
+
+// checked returns the outcome of a lookup as recorded in a result, the checks that didn't run leave it nil
+func checked(f bool) *bool {
+
+	return &f
+}
+
+// validOutput reports whether the output format is supported
+func validOutput(format string) bool {
+
+	return format == outputText || format == outputJSON || format == outputYAML
+}
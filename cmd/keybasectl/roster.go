@@ -0,0 +1,180 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/stefancocora/keybasectl/cmd/keybasectl/keybase"
+	log "github.com/stefancocora/keybasectl/internal/log"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// roster is the declarative description of a team checked by `keybasectl check -f roster.yaml`
+// every member is a keybase user with the rules it must satisfy
+type roster struct {
+	// RequireProofs lists the identity proof services every member must hold a live proof for
+	RequireProofs []string `json:"require_proofs,omitempty" yaml:"require_proofs,omitempty"`
+	// Members lists the members of the team
+	Members []rosterMember `json:"members" yaml:"members"`
+}
+
+// rosterMember is a single member of a roster
+type rosterMember struct {
+	// Username is the expected keybase username of the member
+	Username string `json:"username" yaml:"username"`
+	// Fingerprint pins the fingerprint of the member's primary public key
+	Fingerprint string `json:"fingerprint,omitempty" yaml:"fingerprint,omitempty"`
+	// Proofs lists the identity proofs the member must hold, as service or service:handle
+	Proofs []string `json:"proofs,omitempty" yaml:"proofs,omitempty"`
+	// Metadata is free-form information about the member, it's carried over to the structured output
+	Metadata map[string]string `json:"metadata,omitempty" yaml:"metadata,omitempty"`
+}
+
+// loadRoster reads and validates the roster file at path
+// a .json file is decoded as JSON, anything else as YAML, unknown fields are rejected in both
+func loadRoster(path string) (*roster, error) {
+
+	var ro roster
+
+	b, errrf := ioutil.ReadFile(path)
+	if errrf != nil {
+
+		return nil, errors.Wrapf(errrf, "unable to read the roster file: %s", path)
+	}
+
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.DisallowUnknownFields()
+		if errDec := dec.Decode(&ro); errDec != nil {
+
+			return nil, errors.Wrapf(errDec, "unable to decode the roster file: %s", path)
+		}
+	} else if errDec := yaml.UnmarshalStrict(b, &ro); errDec != nil {
+
+		return nil, errors.Wrapf(errDec, "unable to decode the roster file: %s", path)
+	}
+
+	if errv := ro.validate(); errv != nil {
+
+		return nil, errors.Wrapf(errv, "invalid roster file: %s", path)
+	}
+	log.DebugLog.Printf("roster %s: %d member(s)", path, len(ro.Members))
+
+	return &ro, nil
+}
+
+// validate normalises the roster and checks every member is well formed
+func (ro *roster) validate() error {
+
+	if len(ro.Members) == 0 {
+
+		return errors.New("no members")
+	}
+
+	seen := make(map[string]bool)
+	for i := range ro.Members {
+
+		m := &ro.Members[i]
+		m.Username = strings.ToLower(strings.TrimSpace(m.Username))
+		if m.Username == "" {
+
+			return errors.Errorf("member %d: no username", i+1)
+		}
+		if seen[m.Username] {
+
+			return errors.Errorf("member %s: listed more than once", m.Username)
+		}
+		seen[m.Username] = true
+
+		if m.Fingerprint != "" {
+
+			m.Fingerprint = keybase.NormaliseFingerprint(m.Fingerprint)
+		}
+		for _, p := range m.Proofs {
+
+			if s, _ := splitRosterProof(p); s == "" {
+
+				return errors.Errorf("member %s: invalid proof %q, expected service or service:handle", m.Username, p)
+			}
+		}
+	}
+
+	return nil
+}
+
+// usernames returns the usernames of the members, in the roster order
+func (ro *roster) usernames() []string {
+
+	var users []string
+	for _, m := range ro.Members {
+
+		users = append(users, m.Username)
+	}
+	return users
+}
+
+// pins returns the fingerprint pins of the members
+func (ro *roster) pins() map[string]string {
+
+	pins := make(map[string]string)
+	for _, m := range ro.Members {
+
+		if m.Fingerprint != "" {
+
+			pins[m.Username] = m.Fingerprint
+		}
+	}
+	return pins
+}
+
+// proofExpectations returns the identity proofs every member must hold
+// a proof without a handle is met by a live proof for the service whatever its handle
+func (ro *roster) proofExpectations() []keybase.ProofExpectation {
+
+	var expected []keybase.ProofExpectation
+	for _, m := range ro.Members {
+
+		for _, p := range m.Proofs {
+
+			service, handle := splitRosterProof(p)
+			expected = append(expected, keybase.ProofExpectation{Username: m.Username, Service: service, Handle: handle})
+		}
+	}
+	return expected
+}
+
+// splitRosterProof splits a roster proof in its service and handle, the handle is empty when it's not given
+func splitRosterProof(proof string) (string, string) {
+
+	parts := strings.SplitN(proof, ":", 2)
+	service := strings.TrimSpace(parts[0])
+	if len(parts) == 1 {
+
+		return service, ""
+	}
+
+	handle := strings.TrimSpace(parts[1])
+	if handle == "" {
+
+		return "", ""
+	}
+	return service, handle
+}
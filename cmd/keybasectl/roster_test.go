@@ -0,0 +1,145 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/stefancocora/keybasectl/cmd/keybasectl/keybase"
+)
+
+func TestRosterValidate(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		members []rosterMember
+		err     string // a substring of the expected error, empty when the roster is valid
+	}{
+		{
+			name:    "valid roster",
+			members: []rosterMember{{Username: "alice", Fingerprint: "52A4 5832", Proofs: []string{"github", "twitter:alice_tw"}}, {Username: "bob"}},
+		},
+		{
+			name: "no members",
+			err:  "no members",
+		},
+		{
+			name:    "member without a username",
+			members: []rosterMember{{Username: "alice"}, {Username: " "}},
+			err:     "member 2: no username",
+		},
+		{
+			name:    "member listed twice",
+			members: []rosterMember{{Username: "alice"}, {Username: "Alice "}},
+			err:     "member alice: listed more than once",
+		},
+		{
+			name:    "proof without a service",
+			members: []rosterMember{{Username: "alice", Proofs: []string{":alice-gh"}}},
+			err:     `member alice: invalid proof ":alice-gh"`,
+		},
+		{
+			name:    "proof with an empty handle",
+			members: []rosterMember{{Username: "alice", Proofs: []string{"github: "}}},
+			err:     `member alice: invalid proof "github: "`,
+		},
+	}
+
+	for _, tt := range tests {
+
+		ro := &roster{Members: tt.members}
+		errv := ro.validate()
+		if tt.err == "" {
+
+			if errv != nil {
+
+				t.Errorf("%s: unexpected error: %v", tt.name, errv)
+			}
+			continue
+		}
+		if errv == nil || !strings.Contains(errv.Error(), tt.err) {
+
+			t.Errorf("%s: expected the error %q, got %v", tt.name, tt.err, errv)
+		}
+	}
+}
+
+func TestRosterValidateNormalises(t *testing.T) {
+
+	ro := &roster{Members: []rosterMember{{Username: " Alice ", Fingerprint: "0x52A4 5832 2E92", Proofs: []string{"GitHub:Alice-GH", "dns"}}}}
+	if errv := ro.validate(); errv != nil {
+
+		t.Fatalf("unexpected error: %v", errv)
+	}
+
+	if got := ro.usernames(); !reflect.DeepEqual(got, []string{"alice"}) {
+
+		t.Errorf("expected the normalised username alice, got %v", got)
+	}
+	if got := ro.pins(); !reflect.DeepEqual(got, map[string]string{"alice": "52a458322e92"}) {
+
+		t.Errorf("expected the normalised fingerprint pin, got %v", got)
+	}
+	want := []keybase.ProofExpectation{{Username: "alice", Service: "GitHub", Handle: "Alice-GH"}, {Username: "alice", Service: "dns"}}
+	if got := ro.proofExpectations(); !reflect.DeepEqual(got, want) {
+
+		t.Errorf("expected the proof expectations %+v, got %+v", want, got)
+	}
+}
+
+func TestLoadRoster(t *testing.T) {
+
+	dir := t.TempDir()
+	tests := []struct {
+		file    string
+		content string
+		members int
+		err     bool
+	}{
+		{file: "team.yaml", content: "require_proofs: [github]\nmembers:\n  - username: alice\n    proofs: [twitter]\n  - username: bob\n", members: 2},
+		{file: "team.json", content: `{"members": [{"username": "alice", "metadata": {"team": "backend"}}]}`, members: 1},
+		{file: "unknown.yaml", content: "members:\n  - username: alice\n    email: alice@example.com\n", err: true},
+		{file: "unknown.json", content: `{"members": [{"username": "alice"}], "owner": "bob"}`, err: true},
+		{file: "empty.yaml", content: "members: []\n", err: true},
+	}
+
+	for _, tt := range tests {
+
+		path := filepath.Join(dir, tt.file)
+		if errw := ioutil.WriteFile(path, []byte(tt.content), 0600); errw != nil {
+
+			t.Fatalf("unexpected error: %v", errw)
+		}
+
+		ro, errl := loadRoster(path)
+		if (errl != nil) != tt.err {
+
+			t.Errorf("%s: expected error %v, got %v", tt.file, tt.err, errl)
+			continue
+		}
+		if errl == nil && len(ro.Members) != tt.members {
+
+			t.Errorf("%s: expected %d member(s), got %d", tt.file, tt.members, len(ro.Members))
+		}
+	}
+
+	if _, errl := loadRoster(filepath.Join(dir, "missing.yaml")); errl == nil {
+
+		t.Errorf("expected a missing roster file to be rejected")
+	}
+}
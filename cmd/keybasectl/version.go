@@ -0,0 +1,52 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/stefancocora/keybasectl/internal/version"
+)
+
+// runVersion prints the build metadata of the binary, as text or as a JSON document
+// it returns the process exit value
+func runVersion(cmd *command, args []string) int {
+
+	var asJSON bool
+
+	fs := flag.NewFlagSet(cmd.name, flag.ExitOnError)
+	fs.BoolVar(&asJSON, "json", false, "print the build metadata as a JSON document")
+	fs.Usage = cmd.usage(fs)
+	_ = fs.Parse(args)
+
+	bi := version.BuildInfo()
+	if !asJSON {
+
+		fmt.Fprint(os.Stdout, bi.String())
+		return exitOK
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if errenc := enc.Encode(bi); errenc != nil {
+
+		fmt.Fprintf(os.Stderr, "error : %s\n", errenc.Error())
+		return exitFailure
+	}
+
+	return exitOK
+}
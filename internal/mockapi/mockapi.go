@@ -0,0 +1,320 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package mockapi serves a stand-in for the keybase user lookup API from a directory of JSON fixtures
+//
+// every fixture is a file named <username>.json inside the fixture directory and holds either:
+//   - a single "them" entry, e.g. {"id": "...", "basics": {...}, "public_keys": {...}}
+//     which is returned for that username, trimmed down to the requested fields
+//   - a full lookup response carrying a "status" block, e.g. {"status": {"code": 602, "name": "RATE_LIMIT", "desc": "..."}}
+//     which is returned verbatim whenever that username is part of the request
+//
+// usernames without a fixture are returned as null entries, the same way keybase.io does for unknown users
+//
+// besides usernames, users can be selected by github, twitter, domain and key_fingerprint
+// those are matched against the proofs_summary and public_keys of every fixture
+package mockapi
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	log "github.com/stefancocora/keybasectl/internal/log"
+)
+
+// UserLookupPath is the path the mock serves the user lookup API on
+const UserLookupPath = "/_/api/1.0/user/lookup.json"
+
+// selectors are the query parameters selecting users by an identity other than their username
+var selectors = []string{"github", "twitter", "domain", "key_fingerprint"}
+
+// keybase API status codes used by the mock
+const (
+	statusOK         = 0
+	statusInputError = 100
+)
+
+// validUsername matches the usernames keybase accepts, anything else is an input error
+var validUsername = regexp.MustCompile(`^[a-zA-Z0-9_]{1,16}$`)
+
+// status mirrors the status block of a keybase API response
+type status struct {
+	Code int    `json:"code"`
+	Name string `json:"name"`
+	Desc string `json:"desc,omitempty"`
+}
+
+// lookupResponse mirrors the body of a keybase user lookup API response
+type lookupResponse struct {
+	Status status            `json:"status"`
+	Them   []json.RawMessage `json:"them"`
+}
+
+// Handler serves the keybase user lookup API from a fixture directory
+type Handler struct {
+	dir string
+}
+
+// NewHandler returns a Handler serving the fixtures found in dir
+func NewHandler(dir string) (*Handler, error) {
+
+	fi, errst := os.Stat(dir)
+	if errst != nil {
+
+		return nil, errors.Wrapf(errst, "unable to use the fixture directory: %s", dir)
+	}
+	if !fi.IsDir() {
+
+		return nil, errors.Errorf("the fixture path %s is not a directory", dir)
+	}
+
+	return &Handler{dir: dir}, nil
+}
+
+// ServeHTTP implements the http.Handler interface
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	log.DebugLog.Printf("mock API request: %s %s", r.Method, r.URL)
+
+	if r.URL.Path != UserLookupPath {
+
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	usernames := splitList(query.Get("usernames"))
+	fields := splitList(query.Get("fields"))
+
+	// step: resolve the other selectors to usernames, unresolved values are kept as null entries
+	for _, sel := range selectors {
+
+		values := splitList(query.Get(sel))
+		if len(values) == 0 {
+
+			continue
+		}
+
+		resolved, errrs := h.resolve(sel, values)
+		if errrs != nil {
+
+			log.ErrorLog.Printf("unable to resolve %s %v: %v", sel, values, errrs)
+			http.Error(w, errrs.Error(), http.StatusInternalServerError)
+			return
+		}
+		usernames = append(usernames, resolved...)
+	}
+
+	if len(usernames) == 0 {
+
+		writeJSON(w, lookupResponse{Status: status{Code: statusInputError, Name: "INPUT_ERROR", Desc: "missing usernames"}})
+		return
+	}
+
+	var resp lookupResponse
+	for _, u := range usernames {
+
+		if u == "" {
+
+			resp.Them = append(resp.Them, json.RawMessage("null"))
+			continue
+		}
+		if !validUsername.MatchString(u) {
+
+			writeJSON(w, lookupResponse{Status: status{Code: statusInputError, Name: "INPUT_ERROR", Desc: "bad username: " + u}})
+			return
+		}
+
+		fixture, found, errf := h.fixture(u)
+		if errf != nil {
+
+			log.ErrorLog.Printf("unable to load the fixture for user %s: %v", u, errf)
+			http.Error(w, errf.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !found {
+
+			resp.Them = append(resp.Them, json.RawMessage("null"))
+			continue
+		}
+
+		// a fixture carrying a status block is a canned API response
+		if _, ok := fixture["status"]; ok {
+
+			writeJSON(w, fixture)
+			return
+		}
+
+		entry, errm := json.Marshal(filterFields(fixture, fields))
+		if errm != nil {
+
+			http.Error(w, errm.Error(), http.StatusInternalServerError)
+			return
+		}
+		resp.Them = append(resp.Them, entry)
+	}
+	resp.Status = status{Code: statusOK, Name: "OK"}
+
+	writeJSON(w, resp)
+}
+
+// fixture loads the fixture of the given user, lookups are case insensitive like on keybase.io
+func (h *Handler) fixture(username string) (map[string]json.RawMessage, bool, error) {
+
+	var fixture map[string]json.RawMessage
+
+	b, errrf := ioutil.ReadFile(filepath.Join(h.dir, strings.ToLower(username)+".json"))
+	if os.IsNotExist(errrf) {
+
+		return nil, false, nil
+	}
+	if errrf != nil {
+
+		return nil, false, errrf
+	}
+
+	if errun := json.Unmarshal(b, &fixture); errun != nil {
+
+		return nil, false, errors.Wrapf(errun, "invalid fixture for user %s", username)
+	}
+
+	return fixture, true, nil
+}
+
+// resolve maps every selector value to the username of the fixture holding that identity
+// values matching no fixture resolve to the empty string
+func (h *Handler) resolve(sel string, values []string) ([]string, error) {
+
+	// identity is the subset of a fixture needed to match the selectors
+	var identity struct {
+		PublicKeys struct {
+			Primary struct {
+				Fingerprint string `json:"key_fingerprint"`
+			} `json:"primary"`
+		} `json:"public_keys"`
+		ProofsSummary struct {
+			All []struct {
+				ProofType string `json:"proof_type"`
+				Nametag   string `json:"nametag"`
+			} `json:"all"`
+		} `json:"proofs_summary"`
+	}
+
+	paths, errgl := filepath.Glob(filepath.Join(h.dir, "*.json"))
+	if errgl != nil {
+
+		return nil, errgl
+	}
+
+	owners := make(map[string]string)
+	for _, p := range paths {
+
+		b, errrf := ioutil.ReadFile(p)
+		if errrf != nil {
+
+			return nil, errrf
+		}
+		identity.PublicKeys.Primary.Fingerprint = ""
+		identity.ProofsSummary.All = nil
+		if errun := json.Unmarshal(b, &identity); errun != nil {
+
+			return nil, errors.Wrapf(errun, "invalid fixture %s", p)
+		}
+
+		username := strings.TrimSuffix(filepath.Base(p), ".json")
+		switch sel {
+		case "key_fingerprint":
+			if identity.PublicKeys.Primary.Fingerprint != "" {
+
+				owners[strings.ToLower(identity.PublicKeys.Primary.Fingerprint)] = username
+			}
+		default:
+			for _, pr := range identity.ProofsSummary.All {
+
+				if pr.ProofType == sel || (sel == "domain" && (pr.ProofType == "generic_web_site" || pr.ProofType == "dns")) {
+
+					owners[strings.ToLower(pr.Nametag)] = username
+				}
+			}
+		}
+	}
+
+	resolved := make([]string, 0, len(values))
+	for _, v := range values {
+
+		resolved = append(resolved, owners[strings.ToLower(v)])
+	}
+
+	return resolved, nil
+}
+
+// filterFields trims a "them" entry down to its id and the requested fields, no fields means all of them
+func filterFields(entry map[string]json.RawMessage, fields []string) map[string]json.RawMessage {
+
+	if len(fields) == 0 {
+
+		return entry
+	}
+
+	filtered := make(map[string]json.RawMessage)
+	if id, ok := entry["id"]; ok {
+
+		filtered["id"] = id
+	}
+	for _, f := range fields {
+
+		if v, ok := entry[f]; ok {
+
+			filtered[f] = v
+		}
+	}
+
+	return filtered
+}
+
+// splitList splits a comma separated query parameter, ignoring empty items
+func splitList(val string) []string {
+
+	var items []string
+	for _, i := range strings.Split(val, ",") {
+
+		if i = strings.TrimSpace(i); i != "" {
+
+			items = append(items, i)
+		}
+	}
+
+	return items
+}
+
+// writeJSON writes v as the JSON body of the response
+func writeJSON(w http.ResponseWriter, v interface{}) {
+
+	w.Header().Set("Content-Type", "application/json")
+	if erren := json.NewEncoder(w).Encode(v); erren != nil {
+
+		log.ErrorLog.Printf("unable to write the mock API response: %v", erren)
+	}
+}
@@ -0,0 +1,236 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mockapi
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	log "github.com/stefancocora/keybasectl/internal/log"
+)
+
+// testFixtures holds the fixtures served by newTestServer
+var testFixtures = map[string]string{
+	"alice.json": `{"id": "alice-id", "basics": {"username": "alice"},
+		"public_keys": {"primary": {"kid": "0101", "key_fingerprint": "52a458322e924a5106f2562ac17b21ba395a8d3c"}},
+		"proofs_summary": {"all": [{"proof_type": "github", "nametag": "Alice-GH"}, {"proof_type": "dns", "nametag": "alice.example.com"}]}}`,
+	"ratelimited.json": `{"status": {"code": 602, "name": "RATE_LIMIT", "desc": "rate limit exceeded"}}`,
+}
+
+// newTestServer serves the given fixtures, the server is closed with the test
+func newTestServer(t *testing.T, fixtures map[string]string) *httptest.Server {
+
+	log.LoggingInit(ioutil.Discard, "short", ioutil.Discard, "short", ioutil.Discard, "short")
+
+	dir := t.TempDir()
+	for name, content := range fixtures {
+
+		if errw := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600); errw != nil {
+
+			t.Fatalf("unexpected error: %v", errw)
+		}
+	}
+
+	h, errh := NewHandler(dir)
+	if errh != nil {
+
+		t.Fatalf("unexpected error: %v", errh)
+	}
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestHandler(t *testing.T) {
+
+	srv := newTestServer(t, testFixtures)
+
+	tests := []struct {
+		name   string
+		query  string
+		status int
+		them   []string // the JSON of every "them" entry
+	}{
+		{
+			name:  "every field",
+			query: "usernames=alice",
+			them:  []string{testFixtures["alice.json"]},
+		},
+		{
+			name:  "requested fields only and unknown users as null",
+			query: "usernames=Alice,ghost&fields=basics",
+			them:  []string{`{"id": "alice-id", "basics": {"username": "alice"}}`, `null`},
+		},
+		{
+			name:  "github handles resolve to the fixture holding the proof",
+			query: "github=alice-gh,nobody-gh&fields=basics",
+			them:  []string{`{"id": "alice-id", "basics": {"username": "alice"}}`, `null`},
+		},
+		{
+			name:  "domains resolve to the fixture holding a dns or web proof",
+			query: "domain=Alice.Example.com&fields=basics",
+			them:  []string{`{"id": "alice-id", "basics": {"username": "alice"}}`},
+		},
+		{
+			name:  "key fingerprints resolve to the fixture holding the primary key",
+			query: "key_fingerprint=52A458322E924A5106F2562AC17B21BA395A8D3C&fields=basics",
+			them:  []string{`{"id": "alice-id", "basics": {"username": "alice"}}`},
+		},
+		{
+			name:   "canned status response",
+			query:  "usernames=alice,ratelimited",
+			status: 602,
+		},
+		{
+			name:   "no usernames",
+			query:  "usernames=,",
+			status: statusInputError,
+		},
+		{
+			name:   "invalid username",
+			query:  "usernames=alice,bob!",
+			status: statusInputError,
+		},
+	}
+
+	for _, tt := range tests {
+
+		res, errg := http.Get(srv.URL + UserLookupPath + "?" + tt.query)
+		if errg != nil {
+
+			t.Fatalf("%s: unexpected error: %v", tt.name, errg)
+		}
+		b, errr := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if errr != nil {
+
+			t.Fatalf("%s: unexpected error: %v", tt.name, errr)
+		}
+
+		if res.StatusCode != http.StatusOK {
+
+			t.Errorf("%s: expected http status 200, got %d", tt.name, res.StatusCode)
+			continue
+		}
+
+		var resp struct {
+			Status status            `json:"status"`
+			Them   []json.RawMessage `json:"them"`
+		}
+		if errun := json.Unmarshal(b, &resp); errun != nil {
+
+			t.Errorf("%s: unable to decode the response %s: %v", tt.name, b, errun)
+			continue
+		}
+		if resp.Status.Code != tt.status {
+
+			t.Errorf("%s: expected the status code %d, got %d", tt.name, tt.status, resp.Status.Code)
+		}
+		if len(resp.Them) != len(tt.them) {
+
+			t.Errorf("%s: expected %d entries, got %d", tt.name, len(tt.them), len(resp.Them))
+			continue
+		}
+		for i, want := range tt.them {
+
+			if !jsonEqual(t, want, string(resp.Them[i])) {
+
+				t.Errorf("%s: expected the entry %s, got %s", tt.name, want, resp.Them[i])
+			}
+		}
+	}
+}
+
+func TestHandlerRouting(t *testing.T) {
+
+	srv := newTestServer(t, testFixtures)
+
+	res, errg := http.Get(srv.URL + "/_/api/1.0/user/unknown.json?usernames=alice")
+	if errg != nil {
+
+		t.Fatalf("unexpected error: %v", errg)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNotFound {
+
+		t.Errorf("expected an unknown path to be not found, got %d", res.StatusCode)
+	}
+
+	res, errp := http.Post(srv.URL+UserLookupPath+"?usernames=alice", "application/json", nil)
+	if errp != nil {
+
+		t.Fatalf("unexpected error: %v", errp)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusMethodNotAllowed || res.Header.Get("Allow") != http.MethodGet {
+
+		t.Errorf("expected a POST not to be allowed, got %d allowing %q", res.StatusCode, res.Header.Get("Allow"))
+	}
+}
+
+func TestHandlerInvalidFixture(t *testing.T) {
+
+	srv := newTestServer(t, map[string]string{"broken.json": `{"id": `})
+
+	res, errg := http.Get(srv.URL + UserLookupPath + "?usernames=broken")
+	if errg != nil {
+
+		t.Fatalf("unexpected error: %v", errg)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusInternalServerError {
+
+		t.Errorf("expected an invalid fixture to fail the request, got %d", res.StatusCode)
+	}
+}
+
+func TestNewHandler(t *testing.T) {
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "alice.json")
+	if errw := ioutil.WriteFile(file, []byte(testFixtures["alice.json"]), 0600); errw != nil {
+
+		t.Fatalf("unexpected error: %v", errw)
+	}
+
+	for _, path := range []string{filepath.Join(dir, "missing"), file} {
+
+		if _, errh := NewHandler(path); errh == nil {
+
+			t.Errorf("expected the fixture path %s to be rejected", path)
+		}
+	}
+}
+
+// jsonEqual reports whether both JSON documents hold the same value
+func jsonEqual(t *testing.T, a, b string) bool {
+
+	t.Helper()
+
+	var va, vb interface{}
+	if errun := json.Unmarshal([]byte(a), &va); errun != nil {
+
+		t.Fatalf("invalid JSON %s: %v", a, errun)
+	}
+	if errun := json.Unmarshal([]byte(b), &vb); errun != nil {
+
+		t.Fatalf("invalid JSON %s: %v", b, errun)
+	}
+	return reflect.DeepEqual(va, vb)
+}
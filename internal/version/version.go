@@ -15,6 +15,9 @@ package version
 
 import (
 	"fmt"
+	"runtime"
+	"runtime/debug"
+	"strings"
 
 	"github.com/pkg/errors"
 )
@@ -54,6 +57,59 @@ const BinaryName = "keybasectl"
 // used to set logging context and other useful plumbing
 var AppEnvironment string
 
+// UnknownEnvironment is the application environment of a binary built without the -ldflags of util/build.sh, e.g. by go install
+const UnknownEnvironment = "unknown"
+
+// environments lists the supported application environments
+var environments = []string{"production", "staging", "testing", "dev", UnknownEnvironment}
+
+// UnknownVersion is the version of a binary built without a version, e.g. by a plain go build
+const UnknownVersion = "unknown"
+
+// dirtyMarker is appended to the git commit of a binary built from a tree with uncommitted changes, see util/build.sh
+const dirtyMarker = "+UNCOMMITEDCHANGES"
+
+func init() {
+	fillFromBuildInfo()
+}
+
+// fillFromBuildInfo fills in the build metadata the go link tool didn't set
+// from the build information embedded by the go tooling: module version, vcs.revision, vcs.time and vcs.modified
+func fillFromBuildInfo() {
+	if AppEnvironment == "" {
+		AppEnvironment = UnknownEnvironment
+	}
+	if Buildruntime == "" {
+		Buildruntime = runtime.Version()
+	}
+
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return
+	}
+
+	if Version == "" && bi.Main.Version != "" && bi.Main.Version != "(devel)" {
+		Version = bi.Main.Version
+	}
+
+	vcs := make(map[string]string)
+	for _, s := range bi.Settings {
+		vcs[s.Key] = s.Value
+	}
+	if GitCommit == "" && vcs["vcs.revision"] != "" {
+		GitCommit = vcs["vcs.revision"]
+		if len(GitCommit) > 7 {
+			GitCommit = GitCommit[:7]
+		}
+		if vcs["vcs.modified"] == "true" {
+			GitCommit += dirtyMarker
+		}
+	}
+	if Gitbuilddate == "" {
+		Gitbuilddate = vcs["vcs.time"]
+	}
+}
+
 // Printvers prints the version of the built elf
 //
 // IN:
@@ -61,14 +117,31 @@ var AppEnvironment string
 // OUT
 //   v0.0.1-dev-08c1e21+UNCOMMITEDCHANGES
 func Printvers() (string, error) {
+	version := Version
+	if version == "" {
+		version = UnknownVersion
+	}
+
+	supported := false
+	for _, e := range environments {
+		supported = supported || e == AppEnvironment
+	}
+	if !supported {
+		return version, errors.Errorf("unsupported application environment %q, expected one of %v", AppEnvironment, environments)
+	}
+
 	if AppEnvironment == "production" {
-		return fmt.Sprintf("%v", Version), nil
-	} else if AppEnvironment == "dev" {
-		return fmt.Sprintf("%v-%v-%v", Version, VersionPrerelease, GitCommit), nil
-	} else {
-		// log.Fatalf("[FATAL] unable to work with these application environment %v", AppEnvironment)
-		return "", errors.New(fmt.Sprintf("[FATAL] unable to work with this application environment %v", AppEnvironment))
+		return version, nil
+	}
+
+	// every other environment carries the pre-release marker and the git commit, when known
+	parts := []string{version}
+	for _, p := range []string{VersionPrerelease, GitCommit} {
+		if p != "" {
+			parts = append(parts, p)
+		}
 	}
+	return strings.Join(parts, "-"), nil
 }
 
 // BuildContext prints the DVCS and go build context that have created this package
@@ -103,3 +176,46 @@ func BuildContextCli() (string, error) {
 	}
 	return fmt.Sprintf("\nbinary:%s\nversion:%v\nappenvironment:%v\nruntime:%v\nbranch:%v\nuser:%v\ndate:%v\n", BinaryName, version, AppEnvironment, Buildruntime, Gitbranch, Gitbuilduser, Gitbuilddate), nil
 }
+
+// Info holds the build metadata of the binary, as filled in by the go link tool via -ldflags
+type Info struct {
+	Binary         string `json:"binary"`
+	Version        string `json:"version"`
+	Prerelease     string `json:"prerelease"`
+	GitCommit      string `json:"git_commit"`
+	Branch         string `json:"branch"`
+	BuildUser      string `json:"build_user"`
+	BuildDate      string `json:"build_date"`
+	Runtime        string `json:"runtime"`
+	AppEnvironment string `json:"app_environment"`
+}
+
+// BuildInfo returns the build metadata of the binary
+//
+// IN:
+//
+// OUT:
+//  Info{Binary: "keybasectl", Version: "v0.0.21", Prerelease: "dev", GitCommit: "cd0e1d6+UNCOMMITEDCHANGES", ...}
+func BuildInfo() Info {
+	version := Version
+	if version == "" {
+		version = UnknownVersion
+	}
+
+	return Info{
+		Binary:         BinaryName,
+		Version:        version,
+		Prerelease:     VersionPrerelease,
+		GitCommit:      GitCommit,
+		Branch:         Gitbranch,
+		BuildUser:      Gitbuilduser,
+		BuildDate:      Gitbuilddate,
+		Runtime:        Buildruntime,
+		AppEnvironment: AppEnvironment,
+	}
+}
+
+// String prints the build metadata in a cli friendly way, one key:value per line
+func (i Info) String() string {
+	return fmt.Sprintf("binary:%s\nversion:%s\nprerelease:%s\ncommit:%s\nbranch:%s\nuser:%s\ndate:%s\nruntime:%s\nappenvironment:%s\n", i.Binary, i.Version, i.Prerelease, i.GitCommit, i.Branch, i.BuildUser, i.BuildDate, i.Runtime, i.AppEnvironment)
+}
@@ -71,11 +71,11 @@ function build(){
   if [[ "${ELF_APPENVIRONMENT}" = "dev" ]];
   then
     ELF_VERSIONED="${ELF_NAME}-${ELF_APPENVIRONMENT}-${ELF_VERSION}-${GITCOMMIT_AND_DIRTY}"
-    GOOS=linux GOARCH=amd64 CGO_ENABLED=0 go build -o "./${OUTPUT_DIR}/${ELF_VERSIONED}" -ldflags "${LDFLAGS}" cmd/"${ELF_NAME}"/main.go
+    GOOS=linux GOARCH=amd64 CGO_ENABLED=0 go build -o "./${OUTPUT_DIR}/${ELF_VERSIONED}" -ldflags "${LDFLAGS}" ./cmd/"${ELF_NAME}"
   elif [[ "${ELF_APPENVIRONMENT}" = "production" ]] && [[ "${GIT_DIRTY}" = "" ]] ;
   then
     ELF_VERSIONED="${ELF_NAME}-${ELF_VERSION}-${GITCOMMIT_AND_DIRTY}"
-    GOOS=linux GOARCH=amd64 CGO_ENABLED=0 go build -o "./${OUTPUT_DIR}/${ELF_VERSIONED}" -ldflags "${LDFLAGS}" cmd/"${ELF_NAME}"/main.go
+    GOOS=linux GOARCH=amd64 CGO_ENABLED=0 go build -o "./${OUTPUT_DIR}/${ELF_VERSIONED}" -ldflags "${LDFLAGS}" ./cmd/"${ELF_NAME}"
   elif [[ "${ELF_APPENVIRONMENT}" = "production" ]] && [[ "${GIT_DIRTY}" != "" ]] ;
   then
     date